@@ -1,12 +1,61 @@
 package app
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"os/signal"
+	"syscall"
+
+	"github.com/SerhiiKhyzhko/bookstore_items-api/config"
 	"github.com/SerhiiKhyzhko/bookstore_items-api/controllers"
+	"github.com/SerhiiKhyzhko/bookstore_items-api/metrics"
+	"github.com/SerhiiKhyzhko/bookstore_items-api/requestid"
+	"github.com/SerhiiKhyzhko/bookstore_items-api/tracing"
+	"github.com/SerhiiKhyzhko/bookstore_utils-go/logger"
 	"github.com/gin-gonic/gin"
 )
 
-func StartApp(itemsCtrl *controllers.ItemsController) {
-	router := gin.Default()
-	mapUrls(router, itemsCtrl)
-	router.Run(":8000")
-}
\ No newline at end of file
+// StartApp serves the API until SIGINT or SIGTERM, then stops accepting connections, lets in-flight
+// requests finish within cfg.ShutdownTimeout and calls cleanup to release the backends.
+func StartApp(cfg config.ServerConfig, itemsCtrl *controllers.ItemsController, healthCtrl *controllers.HealthController, cleanup func()) error {
+	router := gin.New()
+	router.Use(gin.Logger(), gin.CustomRecovery(controllers.Recovered))
+	router.Use(tracing.Middleware(), requestid.Middleware(), metrics.Middleware())
+	router.NoRoute(controllers.RouteNotFound)
+	mapUrls(router, itemsCtrl, healthCtrl)
+
+	server := &http.Server{
+		Addr:           ":" + cfg.Port,
+		Handler:        router,
+		ReadTimeout:    cfg.ReadTimeout,
+		WriteTimeout:   cfg.WriteTimeout,
+		IdleTimeout:    cfg.IdleTimeout,
+		MaxHeaderBytes: cfg.MaxHeaderBytes,
+	}
+	defer cleanup()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	select {
+	case err := <-serverErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Info("shutdown signal received, draining in-flight requests")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	logger.Info("server stopped")
+	return nil
+}
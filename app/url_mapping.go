@@ -2,14 +2,29 @@ package app
 
 import (
 	"github.com/SerhiiKhyzhko/bookstore_items-api/controllers"
+	"github.com/SerhiiKhyzhko/bookstore_items-api/metrics"
 	"github.com/gin-gonic/gin"
 )
 
-func mapUrls(router *gin.Engine, itemsCtrl *controllers.ItemsController) {
+func mapUrls(router *gin.Engine, itemsCtrl *controllers.ItemsController, healthCtrl *controllers.HealthController) {
+	router.GET("/health/live", healthCtrl.Live)
+	router.GET("/health/ready", healthCtrl.Ready)
+	router.GET("/metrics", metrics.Handler())
+
 	router.POST("/items", itemsCtrl.Create)
+	router.POST("/items/_bulk", itemsCtrl.Bulk)
+	router.GET("/items/_export", itemsCtrl.Export)
+	router.GET("/items/suggest", itemsCtrl.Suggest)
 	router.GET("/items/:id", itemsCtrl.Get)
 	router.POST("/items/search", itemsCtrl.Search)
 	router.DELETE("/items/:id", itemsCtrl.Delete)
 	router.PATCH("/items/:id", itemsCtrl.Patch)
 	router.PUT("/items/:id", itemsCtrl.Put)
+	router.POST("/items/:id/purchase", itemsCtrl.Purchase)
+	router.POST("/items/:id/reserve", itemsCtrl.Reserve)
+	router.POST("/items/:id/release", itemsCtrl.Release)
+	router.POST("/items/:id/publish", itemsCtrl.Publish)
+	router.POST("/items/:id/pause", itemsCtrl.Pause)
+	router.POST("/items/:id/archive", itemsCtrl.Archive)
+	router.POST("/items/:id/restore", itemsCtrl.Restore)
 }
\ No newline at end of file
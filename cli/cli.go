@@ -0,0 +1,289 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/SerhiiKhyzhko/bookstore-oauth-go/oauth"
+	"github.com/SerhiiKhyzhko/bookstore_items-api/app"
+	"github.com/SerhiiKhyzhko/bookstore_items-api/clients/elasticsearch"
+	"github.com/SerhiiKhyzhko/bookstore_items-api/config"
+	"github.com/SerhiiKhyzhko/bookstore_items-api/controllers"
+	"github.com/SerhiiKhyzhko/bookstore_items-api/domain/items"
+	"github.com/SerhiiKhyzhko/bookstore_items-api/internal/elsticsearch_client"
+	"github.com/SerhiiKhyzhko/bookstore_items-api/services"
+	"github.com/SerhiiKhyzhko/bookstore_items-api/tracing"
+	"github.com/SerhiiKhyzhko/bookstore_utils-go/logger"
+	typedclient "github.com/elastic/go-elasticsearch/v9"
+)
+
+const (
+	usage = `usage:
+  serve                                 start the HTTP server (default)
+  index create                          create the items index if it does not exist
+  index delete -force                   delete the index served under the items alias
+  index reindex [-force]                migrate the items alias to a new index when the mapping changed
+  index status                          show the index served under the items alias
+  items import -seller <id> <file>      apply an NDJSON file of bulk actions
+  items export -seller <id> <file>      write all items of a seller as an NDJSON file of create actions
+  items purge [-retention <duration>]    permanently remove items deleted longer than the retention ago`
+
+	importBatchSize = 1000
+)
+
+var errUsage = errors.New(usage)
+
+// Run executes the subcommand named by args with the loaded configuration.
+func Run(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return serve(cfg)
+	}
+
+	switch args[0] {
+	case "serve":
+		return serve(cfg)
+	case "index":
+		return runIndex(cfg, args[1:])
+	case "items":
+		return runItems(cfg, args[1:])
+	default:
+		return errUsage
+	}
+}
+
+func serve(cfg *config.Config) error {
+	oauth.Init(cfg.OauthBaseUrl)
+	shutdownTracing, err := tracing.Init(context.Background(), cfg.Tracing.Exporter, cfg.Tracing.ServiceName)
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	transport, err := elsticsearch_client.NewTransport(cfg.Elasticsearch)
+	if err != nil {
+		return err
+	}
+	esClient, err := elsticsearch_client.NewElasticClient(cfg.Elasticsearch, transport)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Elasticsearch: %w", err)
+	}
+	if err = elsticsearch_client.EnsureIndexCreated(esClient); err != nil {
+		return fmt.Errorf("failed to check/create index: %w", err)
+	}
+
+	client := newEsClient(cfg, esClient)
+	controller := controllers.NewItemsController(newItemsService(client), cfg.AdminClientIds)
+	healthController := controllers.NewHealthController(services.NewHealthService(client, cfg.OauthBaseUrl))
+	return app.StartApp(cfg.Server, controller, healthController, func() {
+		transport.CloseIdleConnections()
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("error when flushing traces", err)
+		}
+	})
+}
+
+func connect(cfg *config.Config) (*typedclient.TypedClient, error) {
+	transport, err := elsticsearch_client.NewTransport(cfg.Elasticsearch)
+	if err != nil {
+		return nil, err
+	}
+	esClient, err := elsticsearch_client.NewElasticClient(cfg.Elasticsearch, transport)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Elasticsearch: %w", err)
+	}
+	return esClient, nil
+}
+
+func newEsClient(cfg *config.Config, esClient *typedclient.TypedClient) elasticsearch.EsClientInterface {
+	return elasticsearch.NewEsClient(esClient, elasticsearch.Timeouts{
+		Request: cfg.Elasticsearch.RequestTimeout,
+		Search:  cfg.Elasticsearch.SearchTimeout,
+		Bulk:    cfg.Elasticsearch.BulkTimeout,
+	}, elasticsearch.RetryPolicy{
+		MaxAttempts:    cfg.Elasticsearch.Retry.MaxAttempts,
+		InitialBackoff: cfg.Elasticsearch.Retry.InitialBackoff,
+		MaxBackoff:     cfg.Elasticsearch.Retry.MaxBackoff,
+	}, elasticsearch.BreakerSettings{
+		FailureThreshold: cfg.Elasticsearch.Breaker.FailureThreshold,
+		OpenTimeout:      cfg.Elasticsearch.Breaker.OpenTimeout,
+	})
+}
+
+func newItemsService(client elasticsearch.EsClientInterface) services.ItemsServiceInterface {
+	return services.NewItemsService(items.NewItemDao(client))
+}
+
+func runIndex(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	flags := flag.NewFlagSet("index "+args[0], flag.ContinueOnError)
+	force := flags.Bool("force", false, "confirm deletion or reindex an up to date index")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	esClient, err := connect(cfg)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "create":
+		return elsticsearch_client.EnsureIndexCreated(esClient)
+	case "delete":
+		if !*force {
+			return errors.New("index delete removes every item, pass -force to confirm")
+		}
+		return elsticsearch_client.DeleteIndex(esClient)
+	case "reindex":
+		_, err := elsticsearch_client.MigrateIndex(esClient, *force)
+		return err
+	case "status":
+		return printIndexStatus(esClient)
+	default:
+		return errUsage
+	}
+}
+
+func printIndexStatus(esClient *typedclient.TypedClient) error {
+	status, err := elsticsearch_client.GetIndexStatus(esClient)
+	if err != nil {
+		return err
+	}
+	if status == nil {
+		fmt.Printf("index %s does not exist\n", elsticsearch_client.IndexItems)
+		return nil
+	}
+
+	count, err := elsticsearch_client.CountDocuments(esClient)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("alias:      %s\nindex:      %s\nversion:    %d\nup to date: %t\ndocuments:  %d\n",
+		elsticsearch_client.IndexItems, status.Index, status.Version, status.UpToDate, count)
+	return nil
+}
+
+func runItems(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	if args[0] == "purge" {
+		return purgeItems(cfg, args[1:])
+	}
+
+	flags := flag.NewFlagSet("items "+args[0], flag.ContinueOnError)
+	seller := flags.Int64("seller", 0, "seller id the items belong to")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *seller <= 0 || flags.NArg() != 1 {
+		return errUsage
+	}
+
+	esClient, err := connect(cfg)
+	if err != nil {
+		return err
+	}
+	service := newItemsService(newEsClient(cfg, esClient))
+
+	switch args[0] {
+	case "import":
+		return importItems(service, *seller, flags.Arg(0))
+	case "export":
+		return exportItems(service, *seller, flags.Arg(0))
+	default:
+		return errUsage
+	}
+}
+
+func purgeItems(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("items purge", flag.ContinueOnError)
+	retention := flags.Duration("retention", cfg.DeletedRetention, "remove items deleted longer than this ago")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *retention <= 0 || flags.NArg() != 0 {
+		return errUsage
+	}
+
+	esClient, err := connect(cfg)
+	if err != nil {
+		return err
+	}
+
+	purged, err := newItemsService(newEsClient(cfg, esClient)).Purge(context.Background(), *retention)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("purged %d items deleted before %s\n", purged, time.Now().Add(-*retention).UTC().Format(time.RFC3339))
+	return nil
+}
+
+// importItems applies the bulk actions of the file in batches, as an admin so updates and
+// deletes are not limited to items of seller. Failed lines are printed, they do not stop the import.
+func importItems(service services.ItemsServiceInterface, seller int64, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	actions, err := items.ReadBulkActions(file, 0)
+	if err != nil {
+		return err
+	}
+
+	var succeeded, failed int
+	for start := 0; start < len(actions); start += importBatchSize {
+		end := min(start+importBatchSize, len(actions))
+		response, err := service.Bulk(context.Background(), actions[start:end], seller, true)
+		if err != nil {
+			return fmt.Errorf("import stopped at line %d: %w", actions[start].Line, err)
+		}
+
+		for _, result := range response.Items {
+			if result.Error != "" {
+				failed++
+				fmt.Printf("line %d: %s %s failed with status %d: %s\n", result.Line, result.Action, result.Id, result.Status, result.Error)
+				continue
+			}
+			succeeded++
+		}
+	}
+
+	fmt.Printf("imported %d actions, %d failed\n", succeeded, failed)
+	return nil
+}
+
+func exportItems(service services.ItemsServiceInterface, seller int64, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	var exported int
+	err = service.Export(context.Background(), seller, func(batch []items.Item) error {
+		if err := items.WriteBulkCreates(file, batch); err != nil {
+			return err
+		}
+		exported += len(batch)
+		return nil
+	})
+	// the file is only complete once it is closed
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("exported %d items of seller %d to %s\n", exported, seller, path)
+	return nil
+}
@@ -0,0 +1,82 @@
+package elasticsearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/SerhiiKhyzhko/bookstore_items-api/item_errors"
+	"github.com/SerhiiKhyzhko/bookstore_items-api/metrics"
+	"github.com/SerhiiKhyzhko/bookstore_utils-go/logger"
+)
+
+var breakerOpenErr = fmt.Errorf("%w: elasticsearch circuit breaker is open", item_errors.UnavailableErr)
+
+type BreakerSettings struct {
+	FailureThreshold int
+	OpenTimeout      time.Duration
+}
+
+// breaker stops calling a cluster that keeps failing, so requests fail fast instead of piling up
+// on timeouts. After FailureThreshold consecutive transient failures every call is rejected for
+// OpenTimeout, then a single probe call decides whether the breaker closes or stays open.
+type breaker struct {
+	settings BreakerSettings
+
+	mu       sync.Mutex
+	failures int
+	// zero while the breaker is closed
+	openedAt time.Time
+	probing  bool
+}
+
+func newBreaker(settings BreakerSettings) *breaker {
+	return &breaker{settings: settings}
+}
+
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openedAt.IsZero() {
+		return nil
+	}
+	if b.probing || time.Since(b.openedAt) < b.settings.OpenTimeout {
+		return breakerOpenErr
+	}
+	b.probing = true
+	return nil
+}
+
+// record counts the outcome of a call that was allowed, only transient failures count against
+// the cluster, any answer it gave proves it is reachable.
+func (b *breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		// the caller gave up, that says nothing about the cluster
+		b.probing = false
+	case err != nil && isTransient(err):
+		b.failures++
+		if b.probing || (b.openedAt.IsZero() && b.failures >= b.settings.FailureThreshold) {
+			if b.openedAt.IsZero() {
+				logger.Info(fmt.Sprintf("elasticsearch circuit breaker opened after %d failed calls", b.failures))
+			}
+			b.openedAt = time.Now()
+			b.probing = false
+			metrics.SetEsBreakerOpen(true)
+		}
+	default:
+		b.failures = 0
+		b.probing = false
+		if !b.openedAt.IsZero() {
+			b.openedAt = time.Time{}
+			metrics.SetEsBreakerOpen(false)
+			logger.Info("elasticsearch circuit breaker closed")
+		}
+	}
+}
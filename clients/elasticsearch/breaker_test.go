@@ -0,0 +1,76 @@
+package elasticsearch
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	transient := esError(503, "unavailable")
+	permanent := esError(400, "parsing_exception")
+	const openTimeout = 20 * time.Millisecond
+
+	// every step asks the breaker for a call and, when it is allowed, records its outcome
+	type step struct {
+		wait      time.Duration
+		wantAllow error
+		outcome   error
+	}
+	failures := []step{{outcome: transient}, {outcome: transient}, {outcome: transient}}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"stays closed below the threshold", []step{
+			{outcome: transient}, {outcome: transient}, {outcome: nil},
+		}},
+		{"opens at the threshold", append(failures,
+			step{wantAllow: breakerOpenErr},
+		)},
+		{"success resets the failure count", []step{
+			{outcome: transient}, {outcome: transient}, {outcome: nil}, {outcome: transient}, {outcome: transient}, {outcome: nil},
+		}},
+		{"permanent failures don't count", []step{
+			{outcome: permanent}, {outcome: permanent}, {outcome: permanent}, {outcome: nil},
+		}},
+		{"successful probe closes the breaker", append(failures,
+			step{wantAllow: breakerOpenErr}, step{wait: openTimeout, outcome: nil}, step{outcome: transient}, step{outcome: nil},
+		)},
+		{"failed probe reopens the breaker", append(failures,
+			step{wait: openTimeout, outcome: transient}, step{wantAllow: breakerOpenErr},
+		)},
+		{"canceled probe lets another one through", append(failures,
+			step{wait: openTimeout, outcome: context.Canceled}, step{outcome: nil}, step{outcome: nil},
+		)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := newBreaker(BreakerSettings{FailureThreshold: 3, OpenTimeout: openTimeout})
+			for i, s := range test.steps {
+				time.Sleep(s.wait)
+				err := b.allow()
+				if !errors.Is(err, s.wantAllow) {
+					t.Fatalf("step %d: allow() = %v, want %v", i, err, s.wantAllow)
+				}
+				if err == nil {
+					b.record(s.outcome)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerAllowsSingleProbe(t *testing.T) {
+	b := newBreaker(BreakerSettings{FailureThreshold: 1, OpenTimeout: time.Millisecond})
+	b.record(esError(503, "unavailable"))
+	time.Sleep(5 * time.Millisecond)
+
+	if err := b.allow(); err != nil {
+		t.Fatalf("first allow() = %v, want nil", err)
+	}
+	if err := b.allow(); !errors.Is(err, breakerOpenErr) {
+		t.Fatalf("second allow() = %v, want %v", err, breakerOpenErr)
+	}
+}
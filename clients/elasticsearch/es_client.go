@@ -2,111 +2,478 @@ package elasticsearch
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/SerhiiKhyzhko/bookstore_items-api/item_errors"
+	"github.com/SerhiiKhyzhko/bookstore_items-api/metrics"
+	"github.com/SerhiiKhyzhko/bookstore_items-api/tracing"
 	"github.com/SerhiiKhyzhko/bookstore_utils-go/logger"
 	"github.com/elastic/go-elasticsearch/v9"
+	"github.com/elastic/go-elasticsearch/v9/typedapi/cluster/health"
+	"github.com/elastic/go-elasticsearch/v9/typedapi/core/clearscroll"
+	"github.com/elastic/go-elasticsearch/v9/typedapi/core/closepointintime"
 	"github.com/elastic/go-elasticsearch/v9/typedapi/core/get"
+	"github.com/elastic/go-elasticsearch/v9/typedapi/core/mget"
+	"github.com/elastic/go-elasticsearch/v9/typedapi/core/openpointintime"
+	"github.com/elastic/go-elasticsearch/v9/typedapi/core/scroll"
 	"github.com/elastic/go-elasticsearch/v9/typedapi/core/search"
+	"github.com/elastic/go-elasticsearch/v9/typedapi/core/update"
 	"github.com/elastic/go-elasticsearch/v9/typedapi/types"
+	"github.com/elastic/go-elasticsearch/v9/typedapi/types/enums/conflicts"
+	"github.com/elastic/go-elasticsearch/v9/typedapi/types/enums/healthstatus"
 	"github.com/elastic/go-elasticsearch/v9/typedapi/types/enums/optype"
 	"github.com/elastic/go-elasticsearch/v9/typedapi/types/enums/result"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type EsClientInterface interface {
 	Index(context.Context, string, string, any) error
 	Get(context.Context, string, string) (*get.Response, error)
-	Search(context.Context, string, *types.Query, *int, *int) (*search.Response, error)
-	Delete(context.Context, string, string) (bool, error)
-	Update(context.Context, string, string, any) (bool, error)
+	Search(context.Context, string, *search.Request) (*search.Response, error)
+	OpenPointInTime(context.Context, string, string) (string, error)
+	ClosePointInTime(context.Context, string) error
+	Update(context.Context, string, string, any, *DocVersion) (*DocVersion, error)
+	UpdateByScript(context.Context, string, string, string, map[string]any) (result.Result, error)
+	MultiGet(context.Context, string, []string) ([]*types.GetResult, error)
+	Bulk(context.Context, string, []BulkOperation) ([]BulkItemResult, error)
+	ScrollAll(context.Context, string, *types.Query, int, func([]types.Hit) error) error
+	DeleteByQuery(context.Context, string, *types.Query) (int64, error)
+	ClusterHealth(context.Context) (healthstatus.HealthStatus, error)
+	IndexExists(context.Context, string) (bool, error)
+}
+
+const (
+	BulkCreate = "create"
+	BulkUpdate = "update"
+
+	scrollKeepAlive = "1m"
+)
+
+// BulkOperation is a single create or update action of a bulk request, deletes are soft and sent as updates,
+// Document is the full document for create and the partial one for update.
+// An update carrying a Version is only applied if the document is still at that revision.
+type BulkOperation struct {
+	Action   string
+	Id       string
+	Document any
+	Version  *DocVersion
+}
+
+// BulkItemResult is the outcome of one bulk operation, results keep the order of the operations.
+type BulkItemResult struct {
+	Id     string
+	Status int
+	Error  string
+}
+
+// DocVersion is the sequence number and primary term of a stored document,
+// writes carrying it fail with item_errors.ConflictErr if the document has changed since.
+type DocVersion struct {
+	SeqNo       int64
+	PrimaryTerm int64
+}
+
+// startSpan opens the span of a single elasticsearch call, observe closes the loop by recording its outcome.
+func startSpan(ctx context.Context, operation string, index string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	attributes = append(attributes, attribute.String("db.system", "elasticsearch"))
+	if index != "" {
+		attributes = append(attributes, attribute.String("db.elasticsearch.index", index))
+	}
+	return tracing.Start(ctx, "elasticsearch."+operation, attributes...)
+}
+
+// observe records an elasticsearch call in the metrics and on its span, errors are labelled with the
+// elasticsearch error type when the cluster answered and with the failure kind otherwise.
+func observe(span trace.Span, operation string, start time.Time, err error) {
+	errorType := ""
+	var e *types.ElasticsearchError
+	switch {
+	case err == nil:
+	case errors.Is(err, breakerOpenErr):
+		errorType = "breaker_open"
+	case errors.Is(err, context.DeadlineExceeded):
+		errorType = "timeout"
+	case errors.Is(err, context.Canceled):
+		errorType = "canceled"
+	case errors.As(err, &e) && e.ErrorCause.Type != "":
+		errorType = e.ErrorCause.Type
+	case errors.As(err, &e):
+		errorType = fmt.Sprintf("status_%d", e.Status)
+	default:
+		errorType = "transport"
+	}
+	metrics.ObserveEsRequest(operation, time.Since(start), errorType)
+	if errorType != "" {
+		span.SetAttributes(attribute.String("error.type", errorType))
+	}
+	tracing.RecordError(span, err)
+}
+
+// Timeouts bound every elasticsearch call, Search covers searches, scroll pages and multi gets.
+type Timeouts struct {
+	Request time.Duration
+	Search  time.Duration
+	Bulk    time.Duration
 }
 
 type esClient struct {
-	client *elasticsearch.TypedClient
+	client   *elasticsearch.TypedClient
+	timeouts Timeouts
+	retry    RetryPolicy
+	breaker  *breaker
 }
 
-func NewEsClient(client *elasticsearch.TypedClient) *esClient {
-	return &esClient{client: client}
+func NewEsClient(client *elasticsearch.TypedClient, timeouts Timeouts, retry RetryPolicy, breakerSettings BreakerSettings) *esClient {
+	return &esClient{client: client, timeouts: timeouts, retry: retry, breaker: newBreaker(breakerSettings)}
 }
 
 func (c *esClient) Index(ctx context.Context, index string, id string, doc any) error {
+	ctx, span := startSpan(ctx, "index", index, attribute.String("item.id", id))
+	defer span.End()
 
-	esCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
-	defer cancel()
-
-	res, err := c.client.Index(index).Id(id).OpType(optype.Create).Document(doc).Do(esCtx) //OpType - захист від перезапису
+	res, err := call(c, ctx, span, "index", c.timeouts.Request, false,
+		c.client.Index(index).Id(id).OpType(optype.Create).Document(doc).Do) //OpType - захист від перезапису
 
 	if err != nil {
 		logger.Error("error connecting to elasticsearch", err)
-		return err
+		// documents are created with op type create, a conflict means the id is taken
+		if isVersionConflict(err) {
+			return fmt.Errorf("%w: %w", item_errors.AlreadyExistsErr, err)
+		}
+		return classify(err, item_errors.InvalidDocumentErr)
 	}
 
+	span.SetAttributes(attribute.String("db.elasticsearch.result", res.Result.String()))
 	logger.Info(fmt.Sprintf("document indexed: %s, result: %s", res.Id_, res.Result))
 	return nil
 }
 
 func (c *esClient) Get(ctx context.Context, index string, Id string) (*get.Response, error) {
-	esCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
-	defer cancel()
+	ctx, span := startSpan(ctx, "get", index, attribute.String("item.id", Id))
+	defer span.End()
 
-	res, err := c.client.Get(index, Id).Do(esCtx)
+	res, err := call(c, ctx, span, "get", c.timeouts.Request, true, func(esCtx context.Context) (*get.Response, error) {
+		return c.client.Get(index, Id).Do(esCtx)
+	})
 	if err != nil {
 		logger.Error(fmt.Sprintf("error when trying to get id %s", Id), err)
-		return nil, err
+		return nil, classify(err, nil)
 	}
 
+	span.SetAttributes(attribute.Bool("db.elasticsearch.found", res.Found))
 	return res, nil
 }
 
-func (c *esClient) Search(ctx context.Context, index string, query *types.Query, from *int, size *int) (*search.Response, error) {
-	esCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
+func (c *esClient) Search(ctx context.Context, index string, request *search.Request) (*search.Response, error) {
+	ctx, span := startSpan(ctx, "search", index, attribute.Bool("db.elasticsearch.pit", request.Pit != nil))
+	defer span.End()
 
-	result, err := c.client.Search().Index(index).Request(
-		&search.Request{
-			Query: query,
-			From:  from,
-			Size:  size,
-		}).Do(esCtx)
+	result, err := call(c, ctx, span, "search", c.timeouts.Search, true, func(esCtx context.Context) (*search.Response, error) {
+		// typed keys let the client decode aggregations into their concrete types
+		req := c.client.Search().Request(request).TypedKeys(true)
+		// a point in time is already bound to its index, naming the index again is rejected
+		if request.Pit == nil {
+			req = req.Index(index)
+		}
+		return req.Do(esCtx)
+	})
 	if err != nil {
+		var e *types.ElasticsearchError
+		if request.Pit != nil && errors.As(err, &e) && e.Status == http.StatusNotFound {
+			return nil, item_errors.CursorExpiredErr
+		}
 		logger.Error(fmt.Sprintf("Error when trying to search documents in index %s", index), err)
-		return nil, err
+		return nil, classify(err, item_errors.BadQueryErr)
 	}
+	span.SetAttributes(attribute.Int("db.elasticsearch.hits", len(result.Hits.Hits)))
 	return result, nil
 }
 
-func (c *esClient) Delete(ctx context.Context, index string, id string) (bool, error) {
-	esCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
-	defer cancel()
+func (c *esClient) OpenPointInTime(ctx context.Context, index string, keepAlive string) (string, error) {
+	ctx, span := startSpan(ctx, "open_point_in_time", index)
+	defer span.End()
 
-	res, err := c.client.Delete(index, id).Do(esCtx)
+	res, err := call(c, ctx, span, "open_point_in_time", c.timeouts.Request, true, func(esCtx context.Context) (*openpointintime.Response, error) {
+		return c.client.OpenPointInTime(index).KeepAlive(keepAlive).Do(esCtx)
+	})
 	if err != nil {
-		logger.Error(fmt.Sprintf("error when trying to delete document with id %s from index %s", id, index), err)
-		return true, err
+		logger.Error(fmt.Sprintf("error when trying to open point in time on index %s", index), err)
+		return "", classify(err, nil)
 	}
-	
-	if res.Result == result.Notfound {
-		return false, nil
+	return res.Id, nil
+}
+
+func (c *esClient) ClosePointInTime(ctx context.Context, id string) error {
+	ctx, span := startSpan(ctx, "close_point_in_time", "")
+	defer span.End()
+
+	_, err := call(c, ctx, span, "close_point_in_time", c.timeouts.Request, true, func(esCtx context.Context) (*closepointintime.Response, error) {
+		return c.client.ClosePointInTime().Request(&closepointintime.Request{Id: id}).Do(esCtx)
+	})
+	if err != nil {
+		logger.Error("error when trying to close point in time", err)
+		return classify(err, nil)
 	}
-	
-	return true, nil
+	return nil
 }
 
-func (c * esClient) Update(ctx context.Context, index string, id string, doc any) (bool, error) {
-	esCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
-	defer cancel()
+func (c * esClient) Update(ctx context.Context, index string, id string, doc any, version *DocVersion) (*DocVersion, error) {
+	ctx, span := startSpan(ctx, "update", index, attribute.String("item.id", id))
+	defer span.End()
 
-	_, err := c.client.Update(index, id).Doc(doc).Do(esCtx)
+	// a write guarded by a revision can't be applied twice, so only those are retried
+	attempts := 0
+	res, err := call(c, ctx, span, "update", c.timeouts.Request, version != nil, func(esCtx context.Context) (*update.Response, error) {
+		attempts++
+		req := c.client.Update(index, id).Doc(doc)
+		if version != nil {
+			req = req.IfSeqNo(strconv.FormatInt(version.SeqNo, 10)).IfPrimaryTerm(strconv.FormatInt(version.PrimaryTerm, 10))
+		}
+		return req.Do(esCtx)
+	})
 	if err != nil {
-		var e *types.ElasticsearchError
-		if errors.As(err, &e) && e.Status == 404 {
-			return false, nil
+		if isDocumentMissing(err) {
+			return nil, nil
+		}
+		if isVersionConflict(err) {
+			// a retry conflicts with the revision an earlier attempt that timed out may have written itself
+			if attempts > 1 {
+				return nil, fmt.Errorf("%w: update of document %s may have been applied", item_errors.RequestTimeoutErr, id)
+			}
+			return nil, item_errors.ConflictErr
 		}
 		logger.Error(fmt.Sprintf("error when trying to update document with id %s from index %s", id, index), err)
-		return true, err
+		return nil, classify(err, item_errors.InvalidDocumentErr)
+	}
+
+	span.SetAttributes(attribute.String("db.elasticsearch.result", res.Result.String()))
+	return NewDocVersion(res.SeqNo_, res.PrimaryTerm_), nil
+}
+
+// UpdateByScript runs a painless script against a single document, so read-modify-write
+// changes happen atomically inside elasticsearch. A missing document yields result.Notfound,
+// a script that sets ctx.op to 'noop' yields result.Noop.
+func (c *esClient) UpdateByScript(ctx context.Context, index string, id string, source string, params map[string]any) (result.Result, error) {
+	ctx, span := startSpan(ctx, "update_by_script", index, attribute.String("item.id", id))
+	defer span.End()
+
+	script := types.Script{Source: source, Params: make(map[string]json.RawMessage, len(params))}
+	for name, value := range params {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return result.Result{}, err
+		}
+		script.Params[name] = raw
+	}
+
+	res, err := call(c, ctx, span, "update_by_script", c.timeouts.Request, false,
+		c.client.Update(index, id).Script(&script).RetryOnConflict(3).Do)
+	if err != nil {
+		if isDocumentMissing(err) {
+			return result.Notfound, nil
+		}
+		logger.Error(fmt.Sprintf("error when trying to run update script on document with id %s from index %s", id, index), err)
+		return result.Result{}, classify(err, nil)
 	}
 
-	return true, nil
+	span.SetAttributes(attribute.String("db.elasticsearch.result", res.Result.String()))
+	return res.Result, nil
+}
+
+func (c *esClient) MultiGet(ctx context.Context, index string, ids []string) ([]*types.GetResult, error) {
+	ctx, span := startSpan(ctx, "mget", index, attribute.Int("db.elasticsearch.ids", len(ids)))
+	defer span.End()
+
+	res, err := call(c, ctx, span, "mget", c.timeouts.Search, true, func(esCtx context.Context) (*mget.Response, error) {
+		return c.client.Mget().Index(index).Ids(ids...).Do(esCtx)
+	})
+	if err != nil {
+		logger.Error(fmt.Sprintf("error when trying to get %d documents from index %s", len(ids), index), err)
+		return nil, classify(err, nil)
+	}
+
+	docs := make([]*types.GetResult, 0, len(res.Docs))
+	for _, doc := range res.Docs {
+		// per document errors are reported as missing documents
+		if getResult, ok := doc.(*types.GetResult); ok && getResult.Found {
+			docs = append(docs, getResult)
+		}
+	}
+	span.SetAttributes(attribute.Int("db.elasticsearch.found", len(docs)))
+	return docs, nil
+}
+
+func (c *esClient) Bulk(ctx context.Context, index string, operations []BulkOperation) ([]BulkItemResult, error) {
+	ctx, span := startSpan(ctx, "bulk", index, attribute.Int("db.elasticsearch.operations", len(operations)))
+	defer span.End()
+
+	req := c.client.Bulk().Index(index)
+	for _, operation := range operations {
+		id := operation.Id
+		var err error
+		switch operation.Action {
+		case BulkCreate:
+			err = req.CreateOp(types.CreateOperation{Id_: &id}, operation.Document)
+		case BulkUpdate:
+			update := types.UpdateOperation{Id_: &id}
+			if operation.Version != nil {
+				update.IfSeqNo = &operation.Version.SeqNo
+				update.IfPrimaryTerm = &operation.Version.PrimaryTerm
+			}
+			err = req.UpdateOp(update, operation.Document, nil)
+		default:
+			err = fmt.Errorf("unknown bulk action %s", operation.Action)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := call(c, ctx, span, "bulk", c.timeouts.Bulk, false, req.Do)
+	if err != nil {
+		logger.Error(fmt.Sprintf("error when trying to run %d bulk operations on index %s", len(operations), index), err)
+		return nil, classify(err, item_errors.InvalidDocumentErr)
+	}
+
+	span.SetAttributes(attribute.Bool("db.elasticsearch.errors", res.Errors))
+	results := make([]BulkItemResult, 0, len(res.Items))
+	for _, item := range res.Items {
+		for _, responseItem := range item {
+			itemResult := BulkItemResult{Status: responseItem.Status}
+			if responseItem.Id_ != nil {
+				itemResult.Id = *responseItem.Id_
+			}
+			if responseItem.Error != nil {
+				itemResult.Error = responseItem.Error.Type
+				if responseItem.Error.Reason != nil {
+					itemResult.Error += ": " + *responseItem.Error.Reason
+				}
+			}
+			results = append(results, itemResult)
+		}
+	}
+	return results, nil
+}
+
+// ScrollAll walks over every document matching the query in batches of batchSize,
+// stopping at the first error returned by handle.
+func (c *esClient) ScrollAll(ctx context.Context, index string, query *types.Query, batchSize int, handle func([]types.Hit) error) error {
+	ctx, span := startSpan(ctx, "scroll", index)
+	defer span.End()
+
+	// every scroll opens its own search context, so a retried first page could leak one
+	res, err := call(c, ctx, span, "scroll", c.timeouts.Search, false, c.client.Search().Index(index).Scroll(scrollKeepAlive).Request(
+		&search.Request{
+			Query: query,
+			Size:  &batchSize,
+			Sort:  []types.SortCombinations{"_doc"},
+		}).Do)
+	if err != nil {
+		logger.Error(fmt.Sprintf("error when trying to open scroll over index %s", index), err)
+		return classify(err, item_errors.BadQueryErr)
+	}
+
+	scrollId := res.ScrollId_
+	defer c.clearScroll(ctx, scrollId)
+
+	hits := res.Hits.Hits
+	for len(hits) > 0 {
+		if err := handle(hits); err != nil {
+			return err
+		}
+		if scrollId == nil {
+			return nil
+		}
+
+		next, err := call(c, ctx, span, "scroll", c.timeouts.Search, false,
+			c.client.Scroll().Request(&scroll.Request{Scroll: scrollKeepAlive, ScrollId: *scrollId}).Do)
+		if err != nil {
+			logger.Error(fmt.Sprintf("error when trying to scroll over index %s", index), err)
+			return err
+		}
+		scrollId = next.ScrollId_
+		hits = next.Hits.Hits
+	}
+	return nil
+}
+
+// DeleteByQuery permanently removes every document matching the query and returns how many were deleted.
+// Documents changed while the deletion runs no longer match the snapshot it works on and are skipped.
+func (c *esClient) DeleteByQuery(ctx context.Context, index string, query *types.Query) (int64, error) {
+	ctx, span := startSpan(ctx, "delete_by_query", index)
+	defer span.End()
+
+	res, err := call(c, ctx, span, "delete_by_query", c.timeouts.Bulk, false,
+		c.client.DeleteByQuery(index).Query(query).Conflicts(conflicts.Proceed).Refresh(true).Do)
+	if err != nil {
+		logger.Error(fmt.Sprintf("error when trying to delete documents by query from index %s", index), err)
+		return 0, classify(err, item_errors.BadQueryErr)
+	}
+	if len(res.Failures) > 0 {
+		return 0, fmt.Errorf("delete by query failed for %d documents", len(res.Failures))
+	}
+
+	var deleted int64
+	if res.Deleted != nil {
+		deleted = *res.Deleted
+	}
+	span.SetAttributes(attribute.Int64("db.elasticsearch.deleted", deleted))
+	return deleted, nil
+}
+
+func (c *esClient) clearScroll(ctx context.Context, scrollId *string) {
+	if scrollId == nil {
+		return
+	}
+	// the request context may already be gone, the scroll still has to be released
+	ctx, span := startSpan(context.WithoutCancel(ctx), "clear_scroll", "")
+	defer span.End()
+
+	_, err := call(c, ctx, span, "clear_scroll", c.timeouts.Request, false,
+		c.client.ClearScroll().Request(&clearscroll.Request{ScrollId: []string{*scrollId}}).Do)
+	if err != nil {
+		logger.Error("error when trying to clear scroll", err)
+	}
+}
+
+func (c *esClient) ClusterHealth(ctx context.Context) (healthstatus.HealthStatus, error) {
+	ctx, span := startSpan(ctx, "cluster_health", "")
+	defer span.End()
+
+	res, err := call(c, ctx, span, "cluster_health", c.timeouts.Request, true, func(esCtx context.Context) (*health.Response, error) {
+		return c.client.Cluster.Health().Do(esCtx)
+	})
+	if err != nil {
+		logger.Error("error when trying to get cluster health", err)
+		return healthstatus.HealthStatus{}, classify(err, nil)
+	}
+	return res.Status, nil
+}
+
+func (c *esClient) IndexExists(ctx context.Context, index string) (bool, error) {
+	ctx, span := startSpan(ctx, "index_exists", index)
+	defer span.End()
+
+	exists, err := call(c, ctx, span, "index_exists", c.timeouts.Request, true, func(esCtx context.Context) (bool, error) {
+		return c.client.Indices.Exists(index).Do(esCtx)
+	})
+	if err != nil {
+		logger.Error(fmt.Sprintf("error when check the index %s", index), err)
+		return false, classify(err, nil)
+	}
+	return exists, nil
+}
+
+// NewDocVersion builds a DocVersion out of the optional fields of an elasticsearch response.
+func NewDocVersion(seqNo *int64, primaryTerm *int64) *DocVersion {
+	if seqNo == nil || primaryTerm == nil {
+		return nil
+	}
+	return &DocVersion{SeqNo: *seqNo, PrimaryTerm: *primaryTerm}
 }
@@ -0,0 +1,113 @@
+package elasticsearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/SerhiiKhyzhko/bookstore_items-api/item_errors"
+	"github.com/elastic/go-elasticsearch/v9/typedapi/types"
+)
+
+const (
+	indexNotFound = "index_not_found_exception"
+	// raised by the write block an index migration puts on the served index
+	clusterBlock = "cluster_block_exception"
+)
+
+// classify wraps a failed elasticsearch call with the item_errors sentinel describing how it failed,
+// the original error stays in the chain so its details still reach the logs. rejected is the sentinel
+// reported when elasticsearch refuses the request as malformed, with nil those stay unclassified.
+func classify(err error, rejected error) error {
+	if err == nil {
+		return nil
+	}
+	kind := errorKind(err, rejected)
+	if kind == nil {
+		return err
+	}
+	// the reason of a rejected request is shown to the client, the raw error is already logged
+	var e *types.ElasticsearchError
+	if rejected != nil && kind == rejected && errors.As(err, &e) {
+		return fmt.Errorf("%w: %s", kind, rejectedReason(e))
+	}
+	return fmt.Errorf("%w: %w", kind, err)
+}
+
+// rejectedReason picks the most specific explanation elasticsearch gave, the top level one
+// of a search is usually just "all shards failed".
+func rejectedReason(e *types.ElasticsearchError) string {
+	for _, cause := range e.ErrorCause.RootCause {
+		if cause.Reason != nil {
+			return *cause.Reason
+		}
+	}
+	if e.ErrorCause.Reason != nil {
+		return *e.ErrorCause.Reason
+	}
+	return e.ErrorCause.Type
+}
+
+func errorKind(err error, rejected error) error {
+	var e *types.ElasticsearchError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return item_errors.RequestTimeoutErr
+	case errors.Is(err, context.Canceled):
+		// the caller went away, there is nobody to report a failure kind to
+		return nil
+	case errors.As(err, &e):
+		return statusKind(e, rejected)
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return item_errors.RequestTimeoutErr
+	case errors.As(err, &netErr):
+		return item_errors.UnavailableErr
+	// the transport reports a pool without live nodes as a plain error
+	case strings.HasPrefix(err.Error(), "cannot get connection"):
+		return item_errors.UnavailableErr
+	}
+	return nil
+}
+
+func statusKind(e *types.ElasticsearchError, rejected error) error {
+	switch {
+	case e.ErrorCause.Type == indexNotFound:
+		return item_errors.IndexMissingErr
+	case e.ErrorCause.Type == clusterBlock:
+		return item_errors.UnavailableErr
+	case e.Status == http.StatusTooManyRequests:
+		return item_errors.TooManyRequestsErr
+	// conditional writes report a stale revision as ConflictErr on their own, any other conflict
+	// is elasticsearch giving up on concurrent changes of the document
+	case e.Status == http.StatusConflict:
+		return item_errors.ConcurrentUpdateErr
+	case e.Status == http.StatusBadRequest:
+		return rejected
+	case e.Status == http.StatusGatewayTimeout:
+		return item_errors.RequestTimeoutErr
+	case e.Status == http.StatusBadGateway, e.Status == http.StatusServiceUnavailable:
+		return item_errors.UnavailableErr
+	}
+	return nil
+}
+
+func isVersionConflict(err error) bool {
+	var e *types.ElasticsearchError
+	return errors.As(err, &e) && e.Status == http.StatusConflict
+}
+
+// isDocumentMissing tells a missing document apart from a missing index, both answer with 404.
+func isDocumentMissing(err error) bool {
+	var e *types.ElasticsearchError
+	return errors.As(err, &e) && e.Status == http.StatusNotFound && e.ErrorCause.Type != indexNotFound
+}
+
+// isWriteBlocked reports a write rejected while a migration copies the index, the cluster itself is fine.
+func isWriteBlocked(err error) bool {
+	var e *types.ElasticsearchError
+	return errors.As(err, &e) && e.ErrorCause.Type == clusterBlock
+}
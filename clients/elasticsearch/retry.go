@@ -0,0 +1,91 @@
+package elasticsearch
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/SerhiiKhyzhko/bookstore_items-api/item_errors"
+	"github.com/SerhiiKhyzhko/bookstore_items-api/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RetryPolicy decides how often an idempotent call is attempted, 1 attempt disables retries.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// backoff returns how long to wait before the given retry, counting from 1. The wait is drawn
+// at random up to an exponentially growing ceiling so clients failing together don't retry together.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	ceiling := p.MaxBackoff
+	if retry < 32 {
+		if exponential := p.InitialBackoff << (retry - 1); exponential > 0 && exponential < ceiling {
+			ceiling = exponential
+		}
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling) + 1
+}
+
+// isTransient tells failures of the cluster itself, which may go away on their own, apart from
+// answers that would be the same on every attempt such as a missing document or a bad query.
+func isTransient(err error) bool {
+	if isWriteBlocked(err) {
+		return false
+	}
+	kind := errorKind(err, nil)
+	return errors.Is(kind, item_errors.RequestTimeoutErr) ||
+		errors.Is(kind, item_errors.UnavailableErr) ||
+		errors.Is(kind, item_errors.TooManyRequestsErr)
+}
+
+// call runs a single elasticsearch request through the circuit breaker, every attempt is bounded
+// by timeout on its own. Idempotent requests are attempted again after transient failures while the
+// caller's context allows it, do has to build a new request each time as requests can't be resent.
+func call[T any](c *esClient, ctx context.Context, span trace.Span, operation string, timeout time.Duration, idempotent bool, do func(context.Context) (T, error)) (T, error) {
+	attempts := 1
+	if idempotent {
+		attempts = max(c.retry.MaxAttempts, 1)
+	}
+
+	for attempt := 1; ; attempt++ {
+		var res T
+		if err := c.breaker.allow(); err != nil {
+			observe(span, operation, time.Now(), err)
+			return res, err
+		}
+
+		esCtx, cancel := context.WithTimeout(ctx, timeout)
+		start := time.Now()
+		res, err := do(esCtx)
+		cancel()
+		observe(span, operation, start, err)
+		c.breaker.record(err)
+
+		if err == nil || attempt >= attempts || !isTransient(err) || ctx.Err() != nil {
+			return res, err
+		}
+
+		wait := c.retry.backoff(attempt)
+		span.AddEvent("retry", trace.WithAttributes(
+			attribute.Int("attempt", attempt+1),
+			attribute.Int64("backoff_ms", wait.Milliseconds()),
+		))
+		metrics.ObserveEsRetry(operation)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return res, err
+		case <-timer.C:
+		}
+	}
+}
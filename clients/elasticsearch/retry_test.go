@@ -0,0 +1,134 @@
+package elasticsearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SerhiiKhyzhko/bookstore_items-api/item_errors"
+	"github.com/elastic/go-elasticsearch/v9/typedapi/types"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func esError(status int, errorType string) error {
+	e := types.NewElasticsearchError()
+	e.Status = status
+	e.ErrorCause.Type = errorType
+	return e
+}
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		retry   int
+		ceiling time.Duration
+	}{
+		{"first retry", policy, 1, 100 * time.Millisecond},
+		{"doubles", policy, 3, 400 * time.Millisecond},
+		{"capped by max backoff", policy, 5, time.Second},
+		{"shift past 32 retries", policy, 40, time.Second},
+		{"shift overflow", RetryPolicy{InitialBackoff: time.Hour, MaxBackoff: 2 * time.Hour}, 31, 2 * time.Hour},
+		{"no backoff", RetryPolicy{}, 1, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for range 100 {
+				wait := test.policy.backoff(test.retry)
+				if wait < 0 || wait > test.ceiling || (test.ceiling > 0 && wait == 0) {
+					t.Fatalf("backoff(%d) = %s, want in (0, %s]", test.retry, wait, test.ceiling)
+				}
+			}
+		})
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"timeout", context.DeadlineExceeded, true},
+		{"connection refused", fmt.Errorf("query: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}), true},
+		{"no live node", errors.New("cannot get connection: no connection available"), true},
+		{"too many requests", esError(429, "es_rejected_execution_exception"), true},
+		{"cluster unavailable", esError(503, "cluster_block_exception_red"), true},
+		{"write blocked by migration", esError(403, clusterBlock), false},
+		{"canceled by the caller", context.Canceled, false},
+		{"document missing", esError(404, "document_missing_exception"), false},
+		{"bad query", esError(400, "parsing_exception"), false},
+		{"version conflict", esError(409, "version_conflict_engine_exception"), false},
+		{"breaker open", breakerOpenErr, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isTransient(test.err); got != test.want {
+				t.Errorf("isTransient(%v) = %t, want %t", test.err, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCall(t *testing.T) {
+	transient := esError(503, "unavailable")
+	permanent := esError(400, "parsing_exception")
+	tests := []struct {
+		name         string
+		idempotent   bool
+		errs         []error
+		wantAttempts int
+		wantErr      error
+	}{
+		{"success", true, []error{nil}, 1, nil},
+		{"recovers after transient failures", true, []error{transient, transient, nil}, 3, nil},
+		{"gives up after max attempts", true, []error{transient, transient, transient, nil}, 3, transient},
+		{"permanent failure is not retried", true, []error{permanent, nil}, 1, permanent},
+		{"non idempotent call is not retried", false, []error{transient, nil}, 1, transient},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &esClient{
+				retry:   RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+				breaker: newBreaker(BreakerSettings{FailureThreshold: 10, OpenTimeout: time.Minute}),
+			}
+			_, span := noop.NewTracerProvider().Tracer("").Start(context.Background(), "test")
+
+			attempts := 0
+			_, err := call(c, context.Background(), span, "test", time.Second, test.idempotent, func(context.Context) (int, error) {
+				err := test.errs[attempts]
+				attempts++
+				return attempts, err
+			})
+			if attempts != test.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, test.wantAttempts)
+			}
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("err = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestCallStopsWhenCallerGivesUp(t *testing.T) {
+	c := &esClient{
+		retry:   RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Second, MaxBackoff: time.Second},
+		breaker: newBreaker(BreakerSettings{FailureThreshold: 10, OpenTimeout: time.Minute}),
+	}
+	_, span := noop.NewTracerProvider().Tracer("").Start(context.Background(), "test")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	attempts := 0
+	_, err := call(c, ctx, span, "test", time.Second, true, func(context.Context) (int, error) {
+		attempts++
+		return 0, item_errors.UnavailableErr
+	})
+	if attempts != 1 || err == nil {
+		t.Errorf("attempts = %d, err = %v, want a single failed attempt", attempts, err)
+	}
+}
@@ -1,27 +1,266 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"strconv"
+	"time"
 
-	"github.com/SerhiiKhyzhko/bookstore_utils-go/logger"
+	"gopkg.in/yaml.v3"
 )
 
-var (
-	RestyBaseUrl string
-	EsHosts      string
-)
+// Config is the whole service configuration. Load fills it from the defaults, then the optional
+// config file and finally the environment, so env variables always win over the file.
+type Config struct {
+	OauthBaseUrl string `yaml:"oauth_base_url"`
+	// client ids allowed to modify items of any seller
+	AdminClientIds []int64 `yaml:"admin_client_ids"`
+	// how long soft deleted items are kept before the purge job removes them
+	DeletedRetention time.Duration `yaml:"deleted_retention"`
+
+	Server        ServerConfig        `yaml:"server"`
+	Elasticsearch ElasticsearchConfig `yaml:"elasticsearch"`
+	Tracing       TracingConfig       `yaml:"tracing"`
+}
+
+type ServerConfig struct {
+	Port           string        `yaml:"port"`
+	ReadTimeout    time.Duration `yaml:"read_timeout"`
+	WriteTimeout   time.Duration `yaml:"write_timeout"`
+	IdleTimeout    time.Duration `yaml:"idle_timeout"`
+	MaxHeaderBytes int           `yaml:"max_header_bytes"`
+	// how long in-flight requests may take to finish once a shutdown signal arrives
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+type ElasticsearchConfig struct {
+	Addresses []string `yaml:"addresses"`
+	Username  string   `yaml:"username"`
+	Password  string   `yaml:"password"`
+	// PEM file of the CA that signed the cluster certificate, the system pool is used when empty
+	CACertPath string `yaml:"ca_cert_path"`
+	// only meant for local clusters with self-signed certificates
+	InsecureSkipVerify    bool          `yaml:"insecure_skip_verify"`
+	ResponseHeaderTimeout time.Duration `yaml:"response_header_timeout"`
+
+	// every attempt of a call gets its own timeout, retries are waited for on top of it
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+	SearchTimeout  time.Duration `yaml:"search_timeout"`
+	BulkTimeout    time.Duration `yaml:"bulk_timeout"`
+
+	Retry   RetryConfig   `yaml:"retry"`
+	Breaker BreakerConfig `yaml:"breaker"`
+}
+
+// RetryConfig applies to the idempotent elasticsearch calls only, writes are attempted once.
+type RetryConfig struct {
+	// 1 disables retries
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+}
+
+type BreakerConfig struct {
+	// consecutive failed calls that open the breaker
+	FailureThreshold int `yaml:"failure_threshold"`
+	// how long calls fail fast before a single probe call is let through
+	OpenTimeout time.Duration `yaml:"open_timeout"`
+}
+
+type TracingConfig struct {
+	// none, stdout or otlp, the otlp exporter reads the standard OTEL_EXPORTER_OTLP_* variables
+	Exporter    string `yaml:"exporter"`
+	ServiceName string `yaml:"service_name"`
+}
+
+func defaults() Config {
+	return Config{
+		DeletedRetention: 30 * 24 * time.Hour,
+		Server: ServerConfig{
+			Port:            "8000",
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    30 * time.Second,
+			IdleTimeout:     60 * time.Second,
+			MaxHeaderBytes:  1 << 20,
+			ShutdownTimeout: 15 * time.Second,
+		},
+		Elasticsearch: ElasticsearchConfig{
+			ResponseHeaderTimeout: 10 * time.Second,
+			RequestTimeout:        2 * time.Second,
+			SearchTimeout:         5 * time.Second,
+			BulkTimeout:           30 * time.Second,
+			Retry: RetryConfig{
+				MaxAttempts:    3,
+				InitialBackoff: 100 * time.Millisecond,
+				MaxBackoff:     2 * time.Second,
+			},
+			Breaker: BreakerConfig{
+				FailureThreshold: 5,
+				OpenTimeout:      30 * time.Second,
+			},
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			ServiceName: "bookstore_items-api",
+		},
+	}
+}
+
+// Load builds the configuration, path is an optional YAML or JSON file (JSON is valid YAML).
+// Every invalid setting is reported in the returned error instead of the first one only.
+func Load(path string) (*Config, error) {
+	cfg := defaults()
+	if path != "" {
+		if err := cfg.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := errors.Join(cfg.readEnv(), cfg.Validate()); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func (cfg *Config) readFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error when opening config file: %w", err)
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
 
-func Init() {
-	RestyBaseUrl = getRequiredEnv("OAUTH_API_BASE_URL")
-	EsHosts = getRequiredEnv("ES_HOST_ADDRESSES")
+func (cfg *Config) readEnv() error {
+	env := &envReader{}
+
+	env.string("OAUTH_API_BASE_URL", &cfg.OauthBaseUrl)
+	env.idList("ADMIN_CLIENT_IDS", &cfg.AdminClientIds)
+	env.duration("DELETED_RETENTION", &cfg.DeletedRetention)
+
+	env.string("HTTP_PORT", &cfg.Server.Port)
+	env.duration("HTTP_READ_TIMEOUT", &cfg.Server.ReadTimeout)
+	env.duration("HTTP_WRITE_TIMEOUT", &cfg.Server.WriteTimeout)
+	env.duration("HTTP_IDLE_TIMEOUT", &cfg.Server.IdleTimeout)
+	env.int("HTTP_MAX_HEADER_BYTES", &cfg.Server.MaxHeaderBytes)
+	env.duration("SHUTDOWN_TIMEOUT", &cfg.Server.ShutdownTimeout)
+
+	env.list("ES_HOST_ADDRESSES", ";", &cfg.Elasticsearch.Addresses)
+	env.string("ES_USERNAME", &cfg.Elasticsearch.Username)
+	env.string("ES_PASSWORD", &cfg.Elasticsearch.Password)
+	env.string("ES_CA_CERT_PATH", &cfg.Elasticsearch.CACertPath)
+	env.bool("ES_INSECURE_SKIP_VERIFY", &cfg.Elasticsearch.InsecureSkipVerify)
+	env.duration("ES_RESPONSE_HEADER_TIMEOUT", &cfg.Elasticsearch.ResponseHeaderTimeout)
+	env.duration("ES_REQUEST_TIMEOUT", &cfg.Elasticsearch.RequestTimeout)
+	env.duration("ES_SEARCH_TIMEOUT", &cfg.Elasticsearch.SearchTimeout)
+	env.duration("ES_BULK_TIMEOUT", &cfg.Elasticsearch.BulkTimeout)
+	env.int("ES_RETRY_MAX_ATTEMPTS", &cfg.Elasticsearch.Retry.MaxAttempts)
+	env.duration("ES_RETRY_INITIAL_BACKOFF", &cfg.Elasticsearch.Retry.InitialBackoff)
+	env.duration("ES_RETRY_MAX_BACKOFF", &cfg.Elasticsearch.Retry.MaxBackoff)
+	env.int("ES_BREAKER_FAILURE_THRESHOLD", &cfg.Elasticsearch.Breaker.FailureThreshold)
+	env.duration("ES_BREAKER_OPEN_TIMEOUT", &cfg.Elasticsearch.Breaker.OpenTimeout)
+
+	env.string("TRACING_EXPORTER", &cfg.Tracing.Exporter)
+	env.string("OTEL_SERVICE_NAME", &cfg.Tracing.ServiceName)
+
+	return errors.Join(env.errs...)
 }
 
-func getRequiredEnv(key string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		logger.Error(fmt.Sprintf("Critical environment variable %s is missing", key), nil)
-		os.Exit(1)
+// Validate checks the settings that can't be caught while parsing them.
+func (cfg *Config) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if cfg.OauthBaseUrl == "" {
+		invalid("oauth base url is required (OAUTH_API_BASE_URL)")
+	} else if !isHttpUrl(cfg.OauthBaseUrl) {
+		invalid("oauth base url %q is not an http(s) url", cfg.OauthBaseUrl)
+	}
+	for _, id := range cfg.AdminClientIds {
+		if id <= 0 {
+			invalid("admin client id %d must be positive", id)
+		}
+	}
+
+	if port, err := strconv.Atoi(cfg.Server.Port); err != nil || port <= 0 || port > 65535 {
+		invalid("server port %q must be a number between 1 and 65535", cfg.Server.Port)
+	}
+	positive := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"deleted retention", cfg.DeletedRetention},
+		{"server read timeout", cfg.Server.ReadTimeout},
+		{"server write timeout", cfg.Server.WriteTimeout},
+		{"server idle timeout", cfg.Server.IdleTimeout},
+		{"server shutdown timeout", cfg.Server.ShutdownTimeout},
+		{"elasticsearch response header timeout", cfg.Elasticsearch.ResponseHeaderTimeout},
+		{"elasticsearch request timeout", cfg.Elasticsearch.RequestTimeout},
+		{"elasticsearch search timeout", cfg.Elasticsearch.SearchTimeout},
+		{"elasticsearch bulk timeout", cfg.Elasticsearch.BulkTimeout},
+		{"elasticsearch retry initial backoff", cfg.Elasticsearch.Retry.InitialBackoff},
+		{"elasticsearch retry max backoff", cfg.Elasticsearch.Retry.MaxBackoff},
+		{"elasticsearch breaker open timeout", cfg.Elasticsearch.Breaker.OpenTimeout},
+	}
+	for _, timeout := range positive {
+		if timeout.value <= 0 {
+			invalid("%s must be positive", timeout.name)
+		}
+	}
+	if cfg.Server.MaxHeaderBytes <= 0 {
+		invalid("server max header bytes must be positive")
+	}
+
+	if cfg.Elasticsearch.Retry.MaxAttempts < 1 {
+		invalid("elasticsearch retry max attempts must be at least 1")
+	}
+	if cfg.Elasticsearch.Retry.InitialBackoff > cfg.Elasticsearch.Retry.MaxBackoff {
+		invalid("elasticsearch retry initial backoff must not exceed the max backoff")
+	}
+	if cfg.Elasticsearch.Breaker.FailureThreshold < 1 {
+		invalid("elasticsearch breaker failure threshold must be at least 1")
+	}
+
+	if len(cfg.Elasticsearch.Addresses) == 0 {
+		invalid("at least one elasticsearch address is required (ES_HOST_ADDRESSES)")
+	}
+	for _, address := range cfg.Elasticsearch.Addresses {
+		if !isHttpUrl(address) {
+			invalid("elasticsearch address %q is not an http(s) url", address)
+		}
+	}
+	if (cfg.Elasticsearch.Username == "") != (cfg.Elasticsearch.Password == "") {
+		invalid("elasticsearch username and password must be set together")
+	}
+	if cfg.Elasticsearch.CACertPath != "" {
+		if _, err := os.Stat(cfg.Elasticsearch.CACertPath); err != nil {
+			invalid("elasticsearch ca cert: %w", err)
+		}
+	}
+
+	switch cfg.Tracing.Exporter {
+	case "none", "stdout", "otlp":
+	default:
+		invalid("tracing exporter %q must be none, stdout or otlp", cfg.Tracing.Exporter)
+	}
+	if cfg.Tracing.ServiceName == "" {
+		invalid("tracing service name is required")
 	}
-	return value
+
+	return errors.Join(errs...)
+}
+
+func isHttpUrl(value string) bool {
+	parsed, err := url.Parse(value)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
 }
@@ -0,0 +1,230 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// envKeys are cleared before every test so the environment of the machine doesn't leak in.
+var envKeys = []string{
+	"OAUTH_API_BASE_URL", "ADMIN_CLIENT_IDS", "DELETED_RETENTION",
+	"HTTP_PORT", "HTTP_READ_TIMEOUT", "HTTP_WRITE_TIMEOUT", "HTTP_IDLE_TIMEOUT", "HTTP_MAX_HEADER_BYTES", "SHUTDOWN_TIMEOUT",
+	"ES_HOST_ADDRESSES", "ES_USERNAME", "ES_PASSWORD", "ES_CA_CERT_PATH", "ES_INSECURE_SKIP_VERIFY",
+	"ES_RESPONSE_HEADER_TIMEOUT", "ES_REQUEST_TIMEOUT", "ES_SEARCH_TIMEOUT", "ES_BULK_TIMEOUT",
+	"ES_RETRY_MAX_ATTEMPTS", "ES_RETRY_INITIAL_BACKOFF", "ES_RETRY_MAX_BACKOFF",
+	"ES_BREAKER_FAILURE_THRESHOLD", "ES_BREAKER_OPEN_TIMEOUT",
+	"TRACING_EXPORTER", "OTEL_SERVICE_NAME",
+}
+
+var requiredEnv = map[string]string{
+	"OAUTH_API_BASE_URL": "http://oauth:8080",
+	"ES_HOST_ADDRESSES":  "http://es1:9200",
+}
+
+func setEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+	for _, key := range envKeys {
+		t.Setenv(key, "")
+	}
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		file  string
+		check func(t *testing.T, cfg *Config)
+	}{
+		{
+			name: "defaults",
+			env:  requiredEnv,
+			check: func(t *testing.T, cfg *Config) {
+				want := defaults()
+				want.OauthBaseUrl = "http://oauth:8080"
+				want.Elasticsearch.Addresses = []string{"http://es1:9200"}
+				if cfg.Server != want.Server || cfg.Elasticsearch.Retry != want.Elasticsearch.Retry ||
+					cfg.Elasticsearch.Breaker != want.Elasticsearch.Breaker || cfg.Tracing != want.Tracing ||
+					cfg.DeletedRetention != want.DeletedRetention {
+					t.Errorf("Load() = %+v, want %+v", cfg, want)
+				}
+			},
+		},
+		{
+			name: "file",
+			file: `
+oauth_base_url: https://oauth.example.com
+admin_client_ids: [1, 2]
+server:
+  port: "9000"
+elasticsearch:
+  addresses: [http://es1:9200, http://es2:9200]
+  retry:
+    max_attempts: 5
+    initial_backoff: 50ms
+  breaker:
+    open_timeout: 1m
+`,
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.OauthBaseUrl != "https://oauth.example.com" || cfg.Server.Port != "9000" ||
+					!slices.Equal(cfg.AdminClientIds, []int64{1, 2}) ||
+					!slices.Equal(cfg.Elasticsearch.Addresses, []string{"http://es1:9200", "http://es2:9200"}) {
+					t.Errorf("Load() = %+v, want the file settings", cfg)
+				}
+				retry := RetryConfig{MaxAttempts: 5, InitialBackoff: 50 * time.Millisecond, MaxBackoff: 2 * time.Second}
+				if cfg.Elasticsearch.Retry != retry {
+					t.Errorf("Retry = %+v, want %+v", cfg.Elasticsearch.Retry, retry)
+				}
+				breaker := BreakerConfig{FailureThreshold: 5, OpenTimeout: time.Minute}
+				if cfg.Elasticsearch.Breaker != breaker {
+					t.Errorf("Breaker = %+v, want %+v", cfg.Elasticsearch.Breaker, breaker)
+				}
+			},
+		},
+		{
+			name: "env overrides the file",
+			env: map[string]string{
+				"OAUTH_API_BASE_URL":           "http://oauth:8080",
+				"ES_HOST_ADDRESSES":            "http://es1:9200; http://es2:9200",
+				"ADMIN_CLIENT_IDS":             "3, 4",
+				"HTTP_PORT":                    "8080",
+				"ES_RETRY_MAX_ATTEMPTS":        "1",
+				"ES_BREAKER_FAILURE_THRESHOLD": "2",
+				"ES_BREAKER_OPEN_TIMEOUT":      "5s",
+			},
+			file: `
+oauth_base_url: https://oauth.example.com
+server:
+  port: "9000"
+elasticsearch:
+  addresses: [http://es3:9200]
+  retry:
+    max_attempts: 5
+`,
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.OauthBaseUrl != "http://oauth:8080" || cfg.Server.Port != "8080" ||
+					!slices.Equal(cfg.AdminClientIds, []int64{3, 4}) ||
+					!slices.Equal(cfg.Elasticsearch.Addresses, []string{"http://es1:9200", "http://es2:9200"}) {
+					t.Errorf("Load() = %+v, want the env settings", cfg)
+				}
+				if cfg.Elasticsearch.Retry.MaxAttempts != 1 {
+					t.Errorf("Retry.MaxAttempts = %d, want 1", cfg.Elasticsearch.Retry.MaxAttempts)
+				}
+				breaker := BreakerConfig{FailureThreshold: 2, OpenTimeout: 5 * time.Second}
+				if cfg.Elasticsearch.Breaker != breaker {
+					t.Errorf("Breaker = %+v, want %+v", cfg.Elasticsearch.Breaker, breaker)
+				}
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setEnv(t, test.env)
+			path := ""
+			if test.file != "" {
+				path = writeFile(t, test.file)
+			}
+			cfg, err := Load(path)
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			test.check(t, cfg)
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		file string
+		want []string
+	}{
+		{
+			name: "missing required settings",
+			want: []string{"OAUTH_API_BASE_URL", "ES_HOST_ADDRESSES"},
+		},
+		{
+			name: "unknown field in the file",
+			env:  requiredEnv,
+			file: "elasticsearch:\n  adresses: [http://es1:9200]\n",
+			want: []string{"invalid config file", "adresses"},
+		},
+		{
+			name: "unparsable env values",
+			env: map[string]string{
+				"OAUTH_API_BASE_URL":    "http://oauth:8080",
+				"ES_HOST_ADDRESSES":     "http://es1:9200",
+				"ES_RETRY_MAX_ATTEMPTS": "three",
+				"ES_REQUEST_TIMEOUT":    "2",
+				"ADMIN_CLIENT_IDS":      "1,x",
+			},
+			want: []string{"ES_RETRY_MAX_ATTEMPTS", "ES_REQUEST_TIMEOUT", "ADMIN_CLIENT_IDS"},
+		},
+		{
+			name: "invalid values are all reported",
+			env: map[string]string{
+				"OAUTH_API_BASE_URL":           "oauth:8080",
+				"ES_HOST_ADDRESSES":            "http://es1:9200;es2",
+				"HTTP_PORT":                    "70000",
+				"ES_RETRY_MAX_ATTEMPTS":        "0",
+				"ES_RETRY_INITIAL_BACKOFF":     "5s",
+				"ES_BREAKER_FAILURE_THRESHOLD": "0",
+				"ES_BREAKER_OPEN_TIMEOUT":      "-1s",
+				"ES_USERNAME":                  "elastic",
+				"TRACING_EXPORTER":             "jaeger",
+			},
+			want: []string{
+				`oauth base url "oauth:8080"`,
+				`elasticsearch address "es2"`,
+				`server port "70000"`,
+				"retry max attempts",
+				"initial backoff must not exceed",
+				"breaker failure threshold",
+				"breaker open timeout must be positive",
+				"username and password",
+				`tracing exporter "jaeger"`,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setEnv(t, test.env)
+			path := ""
+			if test.file != "" {
+				path = writeFile(t, test.file)
+			}
+			cfg, err := Load(path)
+			if err == nil {
+				t.Fatalf("Load() = %+v, want an error", cfg)
+			}
+			for _, want := range test.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Load() error = %v, want it to mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	setEnv(t, requiredEnv)
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Fatal("Load() error = nil, want an error for a missing file")
+	}
+}
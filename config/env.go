@@ -0,0 +1,100 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envReader overrides config fields with the env variables that are set,
+// collecting parse errors so all of them are reported at once.
+type envReader struct {
+	errs []error
+}
+
+func (r *envReader) invalid(key string, value string, expected string) {
+	r.errs = append(r.errs, fmt.Errorf("invalid value %q in environment variable %s, expected %s", value, key, expected))
+}
+
+func (r *envReader) string(key string, target *string) {
+	if value := os.Getenv(key); value != "" {
+		*target = value
+	}
+}
+
+func (r *envReader) list(key string, separator string, target *[]string) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+
+	var values []string
+	for _, part := range strings.Split(value, separator) {
+		if part = strings.TrimSpace(part); part != "" {
+			values = append(values, part)
+		}
+	}
+	*target = values
+}
+
+func (r *envReader) idList(key string, target *[]int64) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+
+	var ids []int64
+	for _, rawId := range strings.Split(value, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(rawId), 10, 64)
+		if err != nil {
+			r.invalid(key, value, "a comma separated list of ids")
+			return
+		}
+		ids = append(ids, id)
+	}
+	*target = ids
+}
+
+func (r *envReader) duration(key string, target *time.Duration) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		r.invalid(key, value, "a duration like 10s")
+		return
+	}
+	*target = duration
+}
+
+func (r *envReader) int(key string, target *int) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		r.invalid(key, value, "a number")
+		return
+	}
+	*target = number
+}
+
+func (r *envReader) bool(key string, target *bool) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+
+	flag, err := strconv.ParseBool(value)
+	if err != nil {
+		r.invalid(key, value, "true or false")
+		return
+	}
+	*target = flag
+}
@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/SerhiiKhyzhko/bookstore-oauth-go/oauth"
+	"github.com/SerhiiKhyzhko/bookstore_items-api/item_errors"
+	"github.com/SerhiiKhyzhko/bookstore_items-api/requestid"
+	"github.com/SerhiiKhyzhko/bookstore_utils-go/logger"
+	"github.com/gin-gonic/gin"
+)
+
+// error codes clients can branch on, messages are only meant for humans and may change
+const (
+	ErrorCodeBadRequest          = "bad_request"
+	ErrorCodeInvalidBody         = "invalid_body"
+	ErrorCodeValidationFailed    = "validation_failed"
+	ErrorCodeInvalidQuery        = "invalid_query"
+	ErrorCodeCursorExpired       = "cursor_expired"
+	ErrorCodeUnauthorized        = "unauthorized"
+	ErrorCodeForbidden           = "forbidden"
+	ErrorCodeNotFound            = "not_found"
+	ErrorCodeTimeout             = "timeout"
+	ErrorCodeTooManyRequests     = "too_many_requests"
+	ErrorCodeAlreadyExists       = "already_exists"
+	ErrorCodeInvalidDocument     = "invalid_document"
+	ErrorCodeInvalidPrecondition = "invalid_precondition"
+	ErrorCodeVersionConflict     = "version_conflict"
+	ErrorCodeConcurrentUpdate    = "concurrent_update"
+	ErrorCodeOutOfStock          = "out_of_stock"
+	ErrorCodeIllegalTransition   = "illegal_transition"
+	ErrorCodeNotAvailable        = "not_available"
+	ErrorCodeUnavailable         = "service_unavailable"
+	ErrorCodeInternal            = "internal_error"
+)
+
+// how long clients are asked to wait before retrying when the storage is overloaded or down
+const retryAfterSeconds = "1"
+
+// ErrorResponse is the body of every error the API returns.
+type ErrorResponse struct {
+	Status    int                      `json:"status"`
+	Code      string                   `json:"code"`
+	Message   string                   `json:"message"`
+	RequestId string                   `json:"request_id,omitempty"`
+	Details   []item_errors.FieldError `json:"details,omitempty"`
+}
+
+func newErrorResponse(status int, code string, message string) *ErrorResponse {
+	return &ErrorResponse{Status: status, Code: code, Message: message}
+}
+
+func badRequest(message string) *ErrorResponse {
+	return newErrorResponse(http.StatusBadRequest, ErrorCodeBadRequest, message)
+}
+
+func invalidBody(message string) *ErrorResponse {
+	return newErrorResponse(http.StatusBadRequest, ErrorCodeInvalidBody, message)
+}
+
+func forbidden(message string) *ErrorResponse {
+	return newErrorResponse(http.StatusForbidden, ErrorCodeForbidden, message)
+}
+
+// writeError sends errResp tagged with the id of the current request.
+func writeError(c *gin.Context, errResp *ErrorResponse) {
+	errResp.RequestId = requestid.FromContext(c.Request.Context())
+	c.JSON(errResp.Status, errResp)
+}
+
+// requestError maps an error returned by the service to the response the client gets.
+func requestError(reqErr error) *ErrorResponse {
+	switch {
+	case errors.Is(reqErr, item_errors.RequestTimeoutErr):
+		return newErrorResponse(http.StatusGatewayTimeout, ErrorCodeTimeout, "storage did not answer in time")
+	case errors.Is(reqErr, item_errors.UnavailableErr), errors.Is(reqErr, item_errors.IndexMissingErr):
+		return newErrorResponse(http.StatusServiceUnavailable, ErrorCodeUnavailable, "service is temporarily unavailable, retry later")
+	case errors.Is(reqErr, item_errors.TooManyRequestsErr):
+		return newErrorResponse(http.StatusTooManyRequests, ErrorCodeTooManyRequests, "too many requests, retry later")
+	case errors.Is(reqErr, item_errors.NotFoundErr):
+		return newErrorResponse(http.StatusNotFound, ErrorCodeNotFound, "item not found with given id")
+	case errors.Is(reqErr, item_errors.ConflictErr):
+		return newErrorResponse(http.StatusPreconditionFailed, ErrorCodeVersionConflict, "item was modified by another request, fetch it again and retry")
+	case errors.Is(reqErr, item_errors.ConcurrentUpdateErr):
+		return newErrorResponse(http.StatusConflict, ErrorCodeConcurrentUpdate, "item is being modified by another request, retry")
+	case errors.Is(reqErr, item_errors.AlreadyExistsErr):
+		return newErrorResponse(http.StatusConflict, ErrorCodeAlreadyExists, "item with given id already exists")
+	case errors.Is(reqErr, item_errors.OutOfStockErr):
+		return newErrorResponse(http.StatusConflict, ErrorCodeOutOfStock, "not enough items in stock")
+	case errors.Is(reqErr, item_errors.IllegalTransitionErr):
+		return newErrorResponse(http.StatusConflict, ErrorCodeIllegalTransition, reqErr.Error())
+	case errors.Is(reqErr, item_errors.NotAvailableErr):
+		return newErrorResponse(http.StatusConflict, ErrorCodeNotAvailable, "item is not available for sale")
+	case errors.Is(reqErr, item_errors.ForbiddenErr):
+		return forbidden("you are not allowed to modify this item")
+	case errors.Is(reqErr, item_errors.ValidationErr):
+		errResp := newErrorResponse(http.StatusBadRequest, ErrorCodeValidationFailed, "invalid item")
+		var validationErr *item_errors.ValidationError
+		if errors.As(reqErr, &validationErr) {
+			errResp.Details = validationErr.Fields
+		}
+		return errResp
+	case errors.Is(reqErr, item_errors.InvalidDocumentErr):
+		return newErrorResponse(http.StatusBadRequest, ErrorCodeInvalidDocument, "item does not match the index mapping")
+	case errors.Is(reqErr, item_errors.BadQueryErr):
+		return newErrorResponse(http.StatusBadRequest, ErrorCodeInvalidQuery, reqErr.Error())
+	case errors.Is(reqErr, item_errors.CursorExpiredErr):
+		return newErrorResponse(http.StatusBadRequest, ErrorCodeCursorExpired, reqErr.Error())
+	case errors.Is(reqErr, item_errors.ParseErr):
+		return newErrorResponse(http.StatusInternalServerError, ErrorCodeInternal, "error when trying to parse response")
+	default:
+		return newErrorResponse(http.StatusInternalServerError, ErrorCodeInternal, "internal server error")
+	}
+}
+
+// writeRequestError responds to a failed service call, naming the item in the not found message
+// when itemId is set. Server side failures are logged with the request id since their cause
+// is not sent to the client.
+func writeRequestError(c *gin.Context, err error, itemId string) {
+	errResp := requestError(err)
+	if errors.Is(err, item_errors.NotFoundErr) && itemId != "" {
+		errResp.Message = fmt.Sprintf("item not found with given id %s", itemId)
+	}
+	if errResp.Status == http.StatusTooManyRequests || errResp.Status == http.StatusServiceUnavailable {
+		c.Header("Retry-After", retryAfterSeconds)
+	}
+	if errResp.Status >= http.StatusInternalServerError {
+		logger.Error(fmt.Sprintf("request %s %s failed, request id %s", c.Request.Method, c.Request.URL.Path, requestid.FromContext(c.Request.Context())), err)
+	}
+	writeError(c, errResp)
+}
+
+// writeAuthError converts an error of the oauth client to the API error schema.
+func writeAuthError(c *gin.Context, err *oauth.RestErr) {
+	code := ErrorCodeUnauthorized
+	switch {
+	case err.Status == http.StatusForbidden:
+		code = ErrorCodeForbidden
+	case err.Status >= http.StatusInternalServerError:
+		code = ErrorCodeUnavailable
+	case err.Status != http.StatusUnauthorized:
+		code = ErrorCodeBadRequest
+	}
+	writeError(c, newErrorResponse(err.Status, code, err.Message))
+}
+
+// bindError reports a body that doesn't decode into an item, a field of the wrong type
+// is reported like any other invalid field, other decoding failures get the generic message.
+func bindError(err error, message string) *ErrorResponse {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return requestError(&item_errors.ValidationError{Fields: []item_errors.FieldError{{
+			Field:   typeErr.Field,
+			Code:    "invalid_type",
+			Message: fmt.Sprintf("%s must be %s", typeErr.Field, jsonTypeName(typeErr.Type)),
+		}}})
+	}
+	return invalidBody(message)
+}
+
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.String:
+		return "a string"
+	default:
+		return "an object"
+	}
+}
+
+// preconditionFailed writes the response for an If-Match header that can not be satisfied.
+func preconditionFailed(c *gin.Context) {
+	writeError(c, newErrorResponse(http.StatusPreconditionFailed, ErrorCodeInvalidPrecondition, "invalid If-Match header"))
+}
+
+// RouteNotFound answers requests to unknown routes with the API error schema.
+func RouteNotFound(c *gin.Context) {
+	writeError(c, newErrorResponse(http.StatusNotFound, ErrorCodeNotFound, fmt.Sprintf("no route for %s %s", c.Request.Method, c.Request.URL.Path)))
+}
+
+// Recovered answers a request whose handler panicked, gin has already logged the panic.
+func Recovered(c *gin.Context, _ any) {
+	writeError(c, newErrorResponse(http.StatusInternalServerError, ErrorCodeInternal, "internal server error"))
+	c.Abort()
+}
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/SerhiiKhyzhko/bookstore_items-api/item_errors"
+	"github.com/SerhiiKhyzhko/bookstore_items-api/requestid"
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{"timeout", item_errors.RequestTimeoutErr, http.StatusGatewayTimeout, ErrorCodeTimeout},
+		{"unavailable", item_errors.UnavailableErr, http.StatusServiceUnavailable, ErrorCodeUnavailable},
+		{"index missing", item_errors.IndexMissingErr, http.StatusServiceUnavailable, ErrorCodeUnavailable},
+		{"too many requests", item_errors.TooManyRequestsErr, http.StatusTooManyRequests, ErrorCodeTooManyRequests},
+		{"not found", item_errors.NotFoundErr, http.StatusNotFound, ErrorCodeNotFound},
+		{"client precondition failed", item_errors.ConflictErr, http.StatusPreconditionFailed, ErrorCodeVersionConflict},
+		{"concurrent update", item_errors.ConcurrentUpdateErr, http.StatusConflict, ErrorCodeConcurrentUpdate},
+		{"already exists", item_errors.AlreadyExistsErr, http.StatusConflict, ErrorCodeAlreadyExists},
+		{"out of stock", item_errors.OutOfStockErr, http.StatusConflict, ErrorCodeOutOfStock},
+		{"illegal transition", item_errors.IllegalTransitionErr, http.StatusConflict, ErrorCodeIllegalTransition},
+		{"not available", item_errors.NotAvailableErr, http.StatusConflict, ErrorCodeNotAvailable},
+		{"forbidden", item_errors.ForbiddenErr, http.StatusForbidden, ErrorCodeForbidden},
+		{"validation", item_errors.ValidationErr, http.StatusBadRequest, ErrorCodeValidationFailed},
+		{"invalid document", item_errors.InvalidDocumentErr, http.StatusBadRequest, ErrorCodeInvalidDocument},
+		{"bad query", item_errors.BadQueryErr, http.StatusBadRequest, ErrorCodeInvalidQuery},
+		{"cursor expired", item_errors.CursorExpiredErr, http.StatusBadRequest, ErrorCodeCursorExpired},
+		{"parse", item_errors.ParseErr, http.StatusInternalServerError, ErrorCodeInternal},
+		{"unknown", errors.New("boom"), http.StatusInternalServerError, ErrorCodeInternal},
+		{"wrapped by the dao", fmt.Errorf("get failed %w", item_errors.UnavailableErr), http.StatusServiceUnavailable, ErrorCodeUnavailable},
+		{"conflict of a retried write", fmt.Errorf("%w: update of document 1 may have been applied", item_errors.RequestTimeoutErr), http.StatusGatewayTimeout, ErrorCodeTimeout},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			errResp := requestError(test.err)
+			if errResp.Status != test.wantStatus || errResp.Code != test.wantCode {
+				t.Errorf("requestError(%v) = %d %s, want %d %s", test.err, errResp.Status, errResp.Code, test.wantStatus, test.wantCode)
+			}
+		})
+	}
+}
+
+func TestRequestErrorValidationDetails(t *testing.T) {
+	fields := []item_errors.FieldError{{Field: "title", Code: "required", Message: "title is required"}}
+	errResp := requestError(&item_errors.ValidationError{Fields: fields})
+	if errResp.Status != http.StatusBadRequest || !slices.Equal(errResp.Details, fields) {
+		t.Errorf("requestError() = %+v, want a 400 with details %+v", errResp, fields)
+	}
+}
+
+func TestWriteRequestError(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		itemId         string
+		wantStatus     int
+		wantMessage    string
+		wantRetryAfter string
+	}{
+		{"not found names the item", item_errors.NotFoundErr, "42", http.StatusNotFound, "item not found with given id 42", ""},
+		{"not found without item", item_errors.NotFoundErr, "", http.StatusNotFound, "item not found with given id", ""},
+		{"unavailable asks to retry", item_errors.UnavailableErr, "42", http.StatusServiceUnavailable, "service is temporarily unavailable, retry later", retryAfterSeconds},
+		{"overloaded asks to retry", item_errors.TooManyRequestsErr, "", http.StatusTooManyRequests, "too many requests, retry later", retryAfterSeconds},
+		{"timeout", item_errors.RequestTimeoutErr, "42", http.StatusGatewayTimeout, "storage did not answer in time", ""},
+		{"precondition failed", item_errors.ConflictErr, "42", http.StatusPreconditionFailed, "item was modified by another request, fetch it again and retry", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest(http.MethodPut, "/items/42", nil)
+			c.Request.Header.Set(requestid.Header, "request-1")
+			requestid.Middleware()(c)
+
+			writeRequestError(c, test.err, test.itemId)
+
+			if recorder.Code != test.wantStatus {
+				t.Errorf("status = %d, want %d", recorder.Code, test.wantStatus)
+			}
+			if retryAfter := recorder.Header().Get("Retry-After"); retryAfter != test.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", retryAfter, test.wantRetryAfter)
+			}
+			var body ErrorResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body %s is not an error response: %v", recorder.Body, err)
+			}
+			if body.Status != test.wantStatus || body.Message != test.wantMessage || body.RequestId != "request-1" {
+				t.Errorf("body = %+v, want status %d, message %q and request id request-1", body, test.wantStatus, test.wantMessage)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/SerhiiKhyzhko/bookstore_items-api/domain/health"
+	"github.com/SerhiiKhyzhko/bookstore_items-api/services"
+	"github.com/gin-gonic/gin"
+)
+
+type HealthController struct {
+	healthService services.HealthServiceInterface
+}
+
+func NewHealthController(healthService services.HealthServiceInterface) *HealthController {
+	return &HealthController{healthService: healthService}
+}
+
+// Live only tells the process is up and able to serve requests.
+func (h *HealthController) Live(c *gin.Context) {
+	c.JSON(http.StatusOK, map[string]string{"status": health.StatusUp})
+}
+
+func (h *HealthController) Ready(c *gin.Context) {
+	report := h.healthService.Ready(c.Request.Context())
+	if report.Status != health.StatusUp {
+		c.JSON(http.StatusServiceUnavailable, report)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
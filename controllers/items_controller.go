@@ -1,61 +1,135 @@
 package controllers
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/SerhiiKhyzhko/bookstore-oauth-go/oauth"
+	"github.com/SerhiiKhyzhko/bookstore_items-api/clients/elasticsearch"
 	"github.com/SerhiiKhyzhko/bookstore_items-api/domain/items"
 	"github.com/SerhiiKhyzhko/bookstore_items-api/domain/queries"
-	"github.com/SerhiiKhyzhko/bookstore_items-api/item_errors"
 	"github.com/SerhiiKhyzhko/bookstore_items-api/services"
-	"github.com/SerhiiKhyzhko/bookstore_utils-go/rest_errors"
+	"github.com/SerhiiKhyzhko/bookstore_utils-go/logger"
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	maxBulkActions = 5000
+
+	defaultSuggestions = 5
+	maxSuggestions     = 20
+)
+
 type ItemsController struct{
 	itemsService services.ItemsServiceInterface
+	// client ids allowed to modify items of any seller
+	adminClientIds []int64
+}
+
+func NewItemsController(itemsService services.ItemsServiceInterface, adminClientIds []int64) *ItemsController {
+	return &ItemsController{itemsService: itemsService, adminClientIds: adminClientIds}
+}
+
+func (i *ItemsController) isAdmin(clientId int64) bool {
+	return slices.Contains(i.adminClientIds, clientId)
+}
+
+// authenticatedClient authenticates the caller and returns their client id.
+// On failure the error response is already written and 0 is returned.
+func authenticatedClient(c *gin.Context) int64 {
+	if err := oauth.AutenticationRequest(c.Request); err != nil {
+		writeAuthError(c, err)
+		return 0
+	}
+
+	clientId := oauth.GetClientId(c.Request)
+	if clientId <= 0 {
+		writeError(c, newErrorResponse(http.StatusUnauthorized, ErrorCodeUnauthorized, "authentication required"))
+		return 0
+	}
+	return clientId
+}
+
+// setETag exposes the item revision so clients can send it back in If-Match.
+func setETag(c *gin.Context, item *items.Item) {
+	if item.Version != nil {
+		c.Header("ETag", fmt.Sprintf(`"%d-%d"`, item.Version.PrimaryTerm, item.Version.SeqNo))
+	}
+}
+
+// ifMatchVersion parses the If-Match header into the item revision the caller expects.
+// A missing header or "*" means no precondition, a malformed one can never match.
+func ifMatchVersion(c *gin.Context) (*elasticsearch.DocVersion, bool) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" || header == "*" {
+		return nil, true
+	}
+
+	primaryTerm, seqNo, found := strings.Cut(strings.Trim(header, `"`), "-")
+	if !found {
+		return nil, false
+	}
+
+	var version elasticsearch.DocVersion
+	var err error
+	if version.PrimaryTerm, err = strconv.ParseInt(primaryTerm, 10, 64); err != nil {
+		return nil, false
+	}
+	if version.SeqNo, err = strconv.ParseInt(seqNo, 10, 64); err != nil {
+		return nil, false
+	}
+	return &version, true
 }
 
-func NewItemsController(itemsService services.ItemsServiceInterface) *ItemsController {
-	return &ItemsController{itemsService: itemsService}
+// authorizeSeller authenticates the caller and loads the item, making sure the caller owns it
+// or is an admin. On failure the error response is already written and nil is returned.
+func (i *ItemsController) authorizeSeller(c *gin.Context, itemId string) *items.Item {
+	clientId := authenticatedClient(c)
+	if clientId == 0 {
+		return nil
+	}
+	return i.ownedItem(c, clientId, itemId)
 }
 
-func requestError(reqErr error) rest_errors.RestErr {
-	switch {
-	case errors.Is(reqErr, item_errors.RequestTimeoutErr):
-		return rest_errors.NewRestError("request timeout", http.StatusRequestTimeout, "database error", nil)
-	case errors.Is(reqErr, item_errors.NotFoundErr):
-		return rest_errors.NewNotFoundError("item not found with given id")
-	case errors.Is(reqErr, item_errors.ParseErr):
-		return rest_errors.NewInternalServerError("error when trying to parse response", errors.New("database error"))
-	default :
-		return rest_errors.NewInternalServerError("internal server error", errors.New("database error"))
+// ownedItem loads the item for an authenticated client, making sure they own it or are an admin.
+// On failure the error response is already written and nil is returned.
+func (i *ItemsController) ownedItem(c *gin.Context, clientId int64, itemId string) *items.Item {
+	item, err := i.itemsService.Get(c.Request.Context(), itemId)
+	if err != nil {
+		writeRequestError(c, err, itemId)
+		return nil
+	}
+
+	if item.Seller != clientId && !i.isAdmin(clientId) {
+		writeError(c, forbidden("you are not allowed to modify this item"))
+		return nil
 	}
 
+	return item
 }
 
 func (i *ItemsController) Create(c *gin.Context) {
 	ctx := c.Request.Context()
-	if err := oauth.AutenticationRequest(c.Request); err != nil {
-		c.JSON(err.Status, err)
+	clientId := authenticatedClient(c)
+	if clientId == 0 {
 		return
 	}
 
 	var itemRequest items.Item
 	if err := c.ShouldBindJSON(&itemRequest); err != nil {
-		restErr := rest_errors.NewBadRequestError("invalid item json body")
-		c.JSON(restErr.Status(), restErr)
+		writeError(c, bindError(err, "invalid item json body"))
 		return
 	}
 
-	itemRequest.Seller = oauth.GetClientId(c.Request)
+	itemRequest.Seller = clientId
 	result, err := i.itemsService.Create(ctx, itemRequest)
 	if err != nil {
-		restErr := requestError(err)
-		c.JSON(restErr.Status(), restErr.Message())
+		writeRequestError(c, err, "")
 		return
 	}
 
@@ -67,13 +141,10 @@ func (i *ItemsController) Get(c *gin.Context) {
 	itemId := strings.TrimSpace(c.Param("id"))
 	item, err := i.itemsService.Get(ctx, itemId)
 	if err != nil {
-		restErr := requestError(err)
-		if errors.Is(err, item_errors.NotFoundErr) {
-			restErr = rest_errors.NewNotFoundError(fmt.Sprintf("item not found with given id %s", itemId))
-		}
-		c.JSON(restErr.Status(), restErr.Message())
+		writeRequestError(c, err, itemId)
 		return
 	}
+	setETag(c, item)
 	c.JSON(http.StatusOK, item)
 }
 
@@ -81,15 +152,24 @@ func (i *ItemsController) Search(c *gin.Context) {
 	ctx := c.Request.Context()
 	var query queries.EsQuery
 	if err := c.ShouldBindJSON(&query); err != nil {
-		restErr := rest_errors.NewBadRequestError("invalid query json body")
-		c.JSON(restErr.Status(), restErr)
+		writeError(c, invalidBody("invalid query json body"))
 		return
 	}
+	// deleted items are only listed for admins
+	if query.IncludeDeleted {
+		clientId := authenticatedClient(c)
+		if clientId == 0 {
+			return
+		}
+		if !i.isAdmin(clientId) {
+			writeError(c, forbidden("only admins can search deleted items"))
+			return
+		}
+	}
 
 	items, searchErr := i.itemsService.Search(ctx, query)
 	if searchErr != nil {
-		restErr := requestError(searchErr)
-		c.JSON(restErr.Status(), restErr.Message())
+		writeRequestError(c, searchErr, "")
 		return
 	}
 	c.JSON(http.StatusOK, items)
@@ -98,63 +178,294 @@ func (i *ItemsController) Search(c *gin.Context) {
 func (i *ItemsController) Delete(c *gin.Context) {
 	ctx := c.Request.Context()
 	itemId := strings.TrimSpace(c.Param("id"))
-	if deleteErr := i.itemsService.Delete(ctx, itemId); deleteErr != nil {
-		restErr := requestError(deleteErr)
-		if errors.Is(deleteErr, item_errors.NotFoundErr) {
-			restErr = rest_errors.NewNotFoundError(fmt.Sprintf("item not found with given id %s", itemId))
-		}
-		c.JSON(restErr.Status(), restErr)
+	if i.authorizeSeller(c, itemId) == nil {
+		return
+	}
+
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		preconditionFailed(c)
+		return
+	}
+
+	if deleteErr := i.itemsService.Delete(ctx, itemId, version); deleteErr != nil {
+		writeRequestError(c, deleteErr, itemId)
 		return
 	}
 	c.JSON(http.StatusOK, map[string]string{"status": "deleted"})
 }
 
+// Restore brings back a deleted item, the item can't be loaded through authorizeSeller
+// while it is deleted so ownership is checked by the service.
+func (i *ItemsController) Restore(c *gin.Context) {
+	ctx := c.Request.Context()
+	clientId := authenticatedClient(c)
+	if clientId == 0 {
+		return
+	}
+
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		preconditionFailed(c)
+		return
+	}
+
+	itemId := strings.TrimSpace(c.Param("id"))
+	result, err := i.itemsService.Restore(ctx, itemId, version, clientId, i.isAdmin(clientId))
+	if err != nil {
+		writeRequestError(c, err, itemId)
+		return
+	}
+
+	setETag(c, result)
+	c.JSON(http.StatusOK, result)
+}
+
 func (i *ItemsController) Put(c *gin.Context) {
 	ctx := c.Request.Context()
 	itemId := strings.TrimSpace(c.Param("id"))
+	currentItem := i.authorizeSeller(c, itemId)
+	if currentItem == nil {
+		return
+	}
+
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		preconditionFailed(c)
+		return
+	}
 
 	var itemRequest items.Item
 	if err := c.ShouldBindJSON(&itemRequest); err != nil {
-		restErr := rest_errors.NewBadRequestError("invalid update entire item json body")
-		c.JSON(restErr.Status(), restErr)
+		writeError(c, bindError(err, "invalid update entire item json body"))
 		return
 	}
 
 	itemRequest.Id = itemId
+	itemRequest.Seller = currentItem.Seller
+	itemRequest.DateCreated = currentItem.DateCreated
+	itemRequest.Version = version
 
 	result, err := i.itemsService.Put(ctx, itemRequest)
 	if err != nil {
-		restErr := requestError(err)
-		if errors.Is(err, item_errors.NotFoundErr) {
-			restErr = rest_errors.NewNotFoundError(fmt.Sprintf("item not found with given id %s", itemId))
-		}
-		c.JSON(restErr.Status(), restErr.Message())
+		writeRequestError(c, err, itemId)
 		return
 	}
 
+	setETag(c, result)
 	c.JSON(http.StatusOK, result)
 }
 
 func (i *ItemsController) Patch(c *gin.Context) {
 	ctx := c.Request.Context()
 	itemId := strings.TrimSpace(c.Param("id"))
+	if i.authorizeSeller(c, itemId) == nil {
+		return
+	}
+
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		preconditionFailed(c)
+		return
+	}
 
 	var itemRequest items.PartialUpdateItem
 	if err := c.ShouldBindJSON(&itemRequest); err != nil {
-		restErr := rest_errors.NewBadRequestError("invalid update item json body")
-		c.JSON(restErr.Status(), restErr)
+		writeError(c, bindError(err, "invalid update item json body"))
 		return
 	}
+	itemRequest.Version = version
 
 	result, err := i.itemsService.Patch(ctx, itemRequest, itemId)
 	if err != nil {
-		restErr := requestError(err)
-		if errors.Is(err, item_errors.NotFoundErr) {
-			restErr = rest_errors.NewNotFoundError(fmt.Sprintf("item not found with given id %s", itemId))
-		}
-		c.JSON(restErr.Status(), restErr.Message())
+		writeRequestError(c, err, itemId)
+		return
+	}
+
+	setETag(c, result)
+	c.JSON(http.StatusOK, result)
+}
+
+// Purchase is open to every client, except buying reserved stock: reservations aren't tied to the
+// client that made them, so only the seller and admins may use them up.
+func (i *ItemsController) Purchase(c *gin.Context) {
+	i.updateStock(c, i.itemsService.Purchase, func(request items.StockRequest) bool {
+		return request.FromReservation
+	})
+}
+
+// Reserve and Release are limited to the seller and admins, a buyer could otherwise lock the whole
+// stock of an item with reservations that nobody else can release.
+func (i *ItemsController) Reserve(c *gin.Context) {
+	i.updateStock(c, i.itemsService.Reserve, sellerOnly)
+}
+
+func (i *ItemsController) Release(c *gin.Context) {
+	i.updateStock(c, i.itemsService.Release, sellerOnly)
+}
+
+func sellerOnly(items.StockRequest) bool {
+	return true
+}
+
+// updateStock runs a stock operation, requiring the caller to own the item when requiresSeller says so.
+func (i *ItemsController) updateStock(c *gin.Context, operation func(context.Context, string, items.StockRequest) (*items.Item, error), requiresSeller func(items.StockRequest) bool) {
+	ctx := c.Request.Context()
+	itemId := strings.TrimSpace(c.Param("id"))
+	clientId := authenticatedClient(c)
+	if clientId == 0 {
+		return
+	}
+
+	var stockRequest items.StockRequest
+	if err := c.ShouldBindJSON(&stockRequest); err != nil || stockRequest.Quantity <= 0 {
+		writeError(c, invalidBody("invalid stock json body, quantity must be positive"))
+		return
+	}
+	if requiresSeller(stockRequest) && i.ownedItem(c, clientId, itemId) == nil {
+		return
+	}
+
+	result, err := operation(ctx, itemId, stockRequest)
+	if err != nil {
+		writeRequestError(c, err, itemId)
+		return
+	}
+
+	setETag(c, result)
+	c.JSON(http.StatusOK, result)
+}
+
+func (i *ItemsController) Publish(c *gin.Context) {
+	i.changeStatus(c, items.StatusActive)
+}
+
+func (i *ItemsController) Pause(c *gin.Context) {
+	i.changeStatus(c, items.StatusPaused)
+}
+
+func (i *ItemsController) Archive(c *gin.Context) {
+	i.changeStatus(c, items.StatusArchived)
+}
+
+func (i *ItemsController) changeStatus(c *gin.Context, status string) {
+	ctx := c.Request.Context()
+	itemId := strings.TrimSpace(c.Param("id"))
+	if i.authorizeSeller(c, itemId) == nil {
+		return
+	}
+
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		preconditionFailed(c)
 		return
 	}
 
+	result, err := i.itemsService.ChangeStatus(ctx, itemId, status, version)
+	if err != nil {
+		writeRequestError(c, err, itemId)
+		return
+	}
+
+	setETag(c, result)
 	c.JSON(http.StatusOK, result)
 }
+
+func (i *ItemsController) Bulk(c *gin.Context) {
+	ctx := c.Request.Context()
+	clientId := authenticatedClient(c)
+	if clientId == 0 {
+		return
+	}
+
+	actions, err := items.ReadBulkActions(c.Request.Body, maxBulkActions)
+	if err != nil {
+		writeError(c, invalidBody(err.Error()))
+		return
+	}
+
+	result, err := i.itemsService.Bulk(ctx, actions, clientId, i.isAdmin(clientId))
+	if err != nil {
+		writeRequestError(c, err, "")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (i *ItemsController) Export(c *gin.Context) {
+	ctx := c.Request.Context()
+	clientId := authenticatedClient(c)
+	if clientId == 0 {
+		return
+	}
+
+	seller := clientId
+	if rawSeller := strings.TrimSpace(c.Query("seller")); rawSeller != "" {
+		parsedSeller, err := strconv.ParseInt(rawSeller, 10, 64)
+		if err != nil {
+			writeError(c, badRequest("seller must be a number"))
+			return
+		}
+		if parsedSeller != clientId && !i.isAdmin(clientId) {
+			writeError(c, forbidden("you are not allowed to export items of this seller"))
+			return
+		}
+		seller = parsedSeller
+	}
+
+	// an export outlives the server write timeout, it is bounded by the elasticsearch calls instead
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+	// the stream headers are only sent with the first batch, an export failing before it still gets a JSON error
+	startStream := func() {
+		if !c.Writer.Written() {
+			c.Header("Content-Type", "application/x-ndjson")
+			c.Writer.WriteHeaderNow()
+		}
+	}
+	err := i.itemsService.Export(ctx, seller, func(batch []items.Item) error {
+		startStream()
+		if err := items.WriteBulkCreates(c.Writer, batch); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	})
+	if err != nil {
+		// once streaming started the status is sent, the client only sees a truncated body
+		if c.Writer.Written() {
+			logger.Error(fmt.Sprintf("export of items of seller %d interrupted", seller), err)
+			return
+		}
+		writeRequestError(c, err, "")
+		return
+	}
+	startStream()
+}
+
+func (i *ItemsController) Suggest(c *gin.Context) {
+	ctx := c.Request.Context()
+	text := strings.TrimSpace(c.Query("q"))
+	if text == "" {
+		writeError(c, badRequest("query parameter q is required"))
+		return
+	}
+
+	size := defaultSuggestions
+	if rawSize := c.Query("size"); rawSize != "" {
+		parsedSize, err := strconv.Atoi(rawSize)
+		if err != nil || parsedSize <= 0 || parsedSize > maxSuggestions {
+			writeError(c, badRequest(fmt.Sprintf("size must be a number between 1 and %d", maxSuggestions)))
+			return
+		}
+		size = parsedSize
+	}
+
+	titles, err := i.itemsService.Suggest(ctx, text, size)
+	if err != nil {
+		writeRequestError(c, err, "")
+		return
+	}
+
+	c.JSON(http.StatusOK, map[string][]string{"suggestions": titles})
+}
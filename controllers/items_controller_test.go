@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SerhiiKhyzhko/bookstore_items-api/clients/elasticsearch"
+	"github.com/gin-gonic/gin"
+)
+
+func TestIfMatchVersion(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   *elasticsearch.DocVersion
+		wantOk bool
+	}{
+		{"no header", "", nil, true},
+		{"any revision", "*", nil, true},
+		{"quoted", `"3-17"`, &elasticsearch.DocVersion{PrimaryTerm: 3, SeqNo: 17}, true},
+		{"surrounding spaces", ` "3-17" `, &elasticsearch.DocVersion{PrimaryTerm: 3, SeqNo: 17}, true},
+		{"unquoted", "3-17", &elasticsearch.DocVersion{PrimaryTerm: 3, SeqNo: 17}, true},
+		// If-Match compares strongly, a weak tag never matches
+		{"weak", `W/"3-17"`, nil, false},
+		{"no separator", `"317"`, nil, false},
+		{"bad primary term", `"a-17"`, nil, false},
+		{"bad sequence number", `"3-b"`, nil, false},
+		{"list of tags", `"3-17", "3-18"`, nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("PUT", "/items/1", nil)
+			if test.header != "" {
+				c.Request.Header.Set("If-Match", test.header)
+			}
+
+			got, ok := ifMatchVersion(c)
+			if ok != test.wantOk {
+				t.Fatalf("ifMatchVersion() ok = %t, want %t", ok, test.wantOk)
+			}
+			if (got == nil) != (test.want == nil) || (got != nil && *got != *test.want) {
+				t.Errorf("ifMatchVersion() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package health
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Report is the readiness of the service, it is up only when every dependency check is up.
+type Report struct {
+	Status string           `json:"status"`
+	Checks map[string]Check `json:"checks"`
+}
+
+type Check struct {
+	Status  string `json:"status"`
+	Details string `json:"details,omitempty"`
+}
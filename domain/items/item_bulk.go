@@ -0,0 +1,64 @@
+package items
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const maxBulkLineBytes = 1024 * 1024
+
+// ReadBulkActions parses an NDJSON stream of bulk actions, skipping blank lines.
+// maxActions of 0 means no limit.
+func ReadBulkActions(reader io.Reader, maxActions int) ([]BulkAction, error) {
+	var actions []BulkAction
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBulkLineBytes)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		action := BulkAction{Line: lineNumber}
+		if err := json.Unmarshal(line, &action); err != nil {
+			return nil, fmt.Errorf("invalid bulk json on line %d", lineNumber)
+		}
+		actions = append(actions, action)
+
+		if maxActions > 0 && len(actions) > maxActions {
+			return nil, fmt.Errorf("bulk request can not have more than %d actions", maxActions)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.New("invalid bulk ndjson body")
+	}
+	if len(actions) == 0 {
+		return nil, errors.New("bulk request has no actions")
+	}
+
+	return actions, nil
+}
+
+// bulkCreateLine is the NDJSON line of a create action.
+type bulkCreateLine struct {
+	Action string `json:"action"`
+	Item   Item   `json:"item"`
+}
+
+// WriteBulkCreates writes the items as NDJSON create actions, the format ReadBulkActions reads,
+// so an export can be imported again. Reservations don't carry over, reserved units are written as available stock.
+func WriteBulkCreates(writer io.Writer, batch []Item) error {
+	encoder := json.NewEncoder(writer)
+	for _, item := range batch {
+		item.AvailableQuantity += item.ReservedQuantity
+		item.ReservedQuantity = 0
+		if err := encoder.Encode(bulkCreateLine{Action: BulkCreate, Item: item}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package items
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestReadBulkActions(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		maxActions int
+		want       []BulkAction
+		wantErr    string
+	}{
+		{
+			name: "actions keep their line numbers",
+			body: "{\"action\":\"create\",\"item\":{\"title\":\"Go\"}}\n\n  \n{\"action\":\"delete\",\"id\":\"1\"}\n",
+			want: []BulkAction{
+				{Action: "create", Item: []byte(`{"title":"Go"}`), Line: 1},
+				{Action: "delete", Id: "1", Line: 4},
+			},
+		},
+		{
+			name: "crlf and no trailing newline",
+			body: "{\"action\":\"delete\",\"id\":\"1\"}\r\n{\"action\":\"delete\",\"id\":\"2\"}",
+			want: []BulkAction{
+				{Action: "delete", Id: "1", Line: 1},
+				{Action: "delete", Id: "2", Line: 2},
+			},
+		},
+		{
+			name:       "at the limit",
+			body:       "{\"action\":\"delete\",\"id\":\"1\"}\n{\"action\":\"delete\",\"id\":\"2\"}\n",
+			maxActions: 2,
+			want: []BulkAction{
+				{Action: "delete", Id: "1", Line: 1},
+				{Action: "delete", Id: "2", Line: 2},
+			},
+		},
+		{
+			name:       "over the limit",
+			body:       strings.Repeat("{\"action\":\"delete\",\"id\":\"1\"}\n", 3),
+			maxActions: 2,
+			wantErr:    "bulk request can not have more than 2 actions",
+		},
+		{
+			name:    "invalid json",
+			body:    "{\"action\":\"delete\",\"id\":\"1\"}\n{\"action\":\n",
+			wantErr: "invalid bulk json on line 2",
+		},
+		{
+			name:    "line too long",
+			body:    "{\"action\":\"create\",\"item\":{\"title\":\"" + strings.Repeat("a", maxBulkLineBytes) + "\"}}\n",
+			wantErr: "invalid bulk ndjson body",
+		},
+		{
+			name:    "empty body",
+			body:    "\n \n",
+			wantErr: "bulk request has no actions",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actions, err := ReadBulkActions(strings.NewReader(test.body), test.maxActions)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("ReadBulkActions() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadBulkActions() error = %v", err)
+			}
+			if len(actions) != len(test.want) {
+				t.Fatalf("ReadBulkActions() = %d actions, want %d", len(actions), len(test.want))
+			}
+			for i, action := range actions {
+				want := test.want[i]
+				if action.Action != want.Action || action.Id != want.Id || string(action.Item) != string(want.Item) || action.Line != want.Line {
+					t.Errorf("action %d = %+v, want %+v", i, action, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteBulkCreatesRoundTrip(t *testing.T) {
+	exported := []Item{
+		{Id: "1", Title: "Go", AvailableQuantity: 3, ReservedQuantity: 2, SoldQuantity: 7, Status: StatusActive},
+		{Id: "2", Title: "Rust", SoldQuantity: 4, Status: StatusSoldOut},
+	}
+	var buffer bytes.Buffer
+	if err := WriteBulkCreates(&buffer, exported); err != nil {
+		t.Fatalf("WriteBulkCreates() error = %v", err)
+	}
+
+	actions, err := ReadBulkActions(&buffer, 0)
+	if err != nil {
+		t.Fatalf("ReadBulkActions() error = %v", err)
+	}
+	if len(actions) != len(exported) {
+		t.Fatalf("ReadBulkActions() = %d actions, want %d", len(actions), len(exported))
+	}
+	tests := []struct {
+		name      string
+		available int
+		sold      int
+	}{
+		{"reserved units become available", 5, 7},
+		{"sold out", 0, 4},
+	}
+	for index, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			action := actions[index]
+			if action.Action != BulkCreate || action.Line != index+1 {
+				t.Fatalf("action = %+v, want a create on line %d", action, index+1)
+			}
+			var item Item
+			if err := json.Unmarshal(action.Item, &item); err != nil {
+				t.Fatalf("item json error = %v", err)
+			}
+			if item.Title != exported[index].Title || item.Status != exported[index].Status ||
+				item.AvailableQuantity != test.available || item.ReservedQuantity != 0 || item.SoldQuantity != test.sold {
+				t.Errorf("item = %+v, want %s with %d available and %d sold", item, exported[index].Title, test.available, test.sold)
+			}
+		})
+	}
+}
@@ -5,23 +5,74 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
+	"time"
 
 	"github.com/SerhiiKhyzhko/bookstore_items-api/clients/elasticsearch"
 	"github.com/SerhiiKhyzhko/bookstore_items-api/domain/queries"
 	"github.com/SerhiiKhyzhko/bookstore_items-api/item_errors"
+	"github.com/elastic/go-elasticsearch/v9/typedapi/core/search"
+	"github.com/elastic/go-elasticsearch/v9/typedapi/types"
+	"github.com/elastic/go-elasticsearch/v9/typedapi/types/enums/result"
+	"github.com/elastic/go-elasticsearch/v9/typedapi/types/enums/textquerytype"
 )
 
 const (
 	indexItems = "items"
+	exportBatchSize = 500
+	// stockScript moves quantities between available, reserved and sold stock in one atomic update,
+	// leaving the document untouched (noop) when the stock would go below zero, the item is deleted
+	// or doesn't accept orders, only releasing reservations works in every status
+	stockScript = `
+		int available = ctx._source.available_quantity;
+		int reserved = ctx._source.reserved_quantity == null ? 0 : ctx._source.reserved_quantity;
+		int sold = ctx._source.sold_quantity;
+		int quantity = params.quantity;
+		if (params.operation == 'reserve') {
+			available -= quantity;
+			reserved += quantity;
+		} else if (params.operation == 'release') {
+			reserved -= quantity;
+			available += quantity;
+		} else if (params.from_reservation) {
+			reserved -= quantity;
+			sold += quantity;
+		} else {
+			available -= quantity;
+			sold += quantity;
+		}
+		String status = ctx._source.status;
+		boolean acceptsOrders = status == params.active_status || status == params.sold_out_status;
+		if (ctx._source.deleted_at != null || (params.operation != 'release' && !acceptsOrders) || available < 0 || reserved < 0) {
+			ctx.op = 'noop';
+		} else {
+			ctx._source.available_quantity = available;
+			ctx._source.reserved_quantity = reserved;
+			ctx._source.sold_quantity = sold;
+			if (available == 0 && status == params.active_status) {
+				ctx._source.status = params.sold_out_status;
+			} else if (available > 0 && status == params.sold_out_status) {
+				ctx._source.status = params.active_status;
+			}
+			ctx._source.date_updated = params.now;
+		}`
 )
 
 type ItemDaoInterface interface {
 	Save(context.Context, Item) error
 	Get(context.Context, string) (*Item, error)
-	Search(context.Context, queries.EsQuery) ([]Item, error)
-	Delete(context.Context, string) error
-	Put(context.Context, Item) error
+	Search(context.Context, queries.EsQuery) (*SearchResult, error)
+	GetWithDeleted(context.Context, string) (*Item, error)
+	Delete(context.Context, string, *elasticsearch.DocVersion) error
+	Restore(context.Context, string, *elasticsearch.DocVersion) error
+	Purge(context.Context, time.Time) (int64, error)
+	Put(context.Context, *Item) error
 	Patch(context.Context, PartialUpdateItem, string) error
+	UpdateStock(context.Context, string, StockOperation, StockRequest) error
+	GetMany(context.Context, []string) (map[string]*Item, error)
+	Bulk(context.Context, []elasticsearch.BulkOperation) ([]elasticsearch.BulkItemResult, error)
+	Export(context.Context, int64, func([]Item) error) error
+	Suggest(context.Context, string, int) ([]string, error)
 }
 
 type itemDaoStruct struct {
@@ -35,21 +86,27 @@ func NewItemDao(esClient elasticsearch.EsClientInterface) *itemDaoStruct {
 func (d *itemDaoStruct) Save(ctx context.Context, item Item) error {
 	err := d.client.Index(ctx, indexItems, item.Id, item)
 	if err != nil {
-		if errors.Is(err, context.DeadlineExceeded) {
-			return item_errors.RequestTimeoutErr
-		}
 		return fmt.Errorf("save failed %w", err)
 	}
 	return nil
 }
 
+// Get loads an item, soft deleted items are reported as not found.
 func (d *itemDaoStruct) Get(ctx context.Context, id string) (*Item, error) {
+	item, err := d.GetWithDeleted(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if item.DeletedAt != nil {
+		return nil, item_errors.NotFoundErr
+	}
+	return item, nil
+}
+
+func (d *itemDaoStruct) GetWithDeleted(ctx context.Context, id string) (*Item, error) {
 	var item Item
 	result, err := d.client.Get(ctx, indexItems, id)
 	if err != nil {
-		if errors.Is(err, context.DeadlineExceeded) {
-			return nil, item_errors.RequestTimeoutErr
-		}
 		return nil, fmt.Errorf("get failed %w", err)
 	}
 
@@ -62,61 +119,375 @@ func (d *itemDaoStruct) Get(ctx context.Context, id string) (*Item, error) {
 	}
 
 	item.Id = result.Id_
+	item.Version = elasticsearch.NewDocVersion(result.SeqNo_, result.PrimaryTerm_)
 
 	return &item, nil
 }
 
-func (d *itemDaoStruct) Search(ctx context.Context, query queries.EsQuery) ([]Item, error) {
-	searchRequest, err := d.client.Search(ctx, indexItems, query.Build(), query.From, query.Size)
+func (d *itemDaoStruct) Search(ctx context.Context, query queries.EsQuery) (*SearchResult, error) {
+	request := &search.Request{
+		Query: query.Build(),
+		From:  query.From,
+		Size:  query.Size,
+		Sort:  query.BuildSort(),
+
+		Aggregations: query.BuildAggregations(),
+		Highlight:    query.BuildHighlight(),
+	}
+
+	cursor, err := d.searchCursor(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	pageSize := query.PageSize()
+	if cursor != nil {
+		request.Pit = &types.PointInTimeReference{Id: cursor.PitId, KeepAlive: queries.CursorKeepAlive}
+		// search_after needs a sort, point in time adds the _shard_doc tie breaker on its own
+		if len(request.Sort) == 0 {
+			request.Sort = []types.SortCombinations{"_score"}
+		}
+		request.SearchAfter = cursor.SearchAfter
+		request.Size = &pageSize
+	}
+
+	searchRequest, err := d.client.Search(ctx, indexItems, request)
 	if err != nil {
+		// a point in time opened for this first page would stay open on the cluster until it expires
+		if query.Cursor == nil && cursor != nil {
+			_ = d.client.ClosePointInTime(context.WithoutCancel(ctx), cursor.PitId)
+		}
+		if errors.Is(err, item_errors.CursorExpiredErr) || errors.Is(err, item_errors.BadQueryErr) {
+			return nil, err
+		}
 		return nil, fmt.Errorf("search failed %w", err)
 	}
-	result := make([]Item, len(searchRequest.Hits.Hits))
+	result := &SearchResult{
+		TookMs: searchRequest.Took,
+		Size:   pageSize,
+		Items:  make([]Item, len(searchRequest.Hits.Hits)),
+	}
+	if query.From != nil {
+		result.From = *query.From
+	}
+	if total := searchRequest.Hits.Total; total != nil {
+		result.Total = SearchTotal{Value: total.Value, Relation: total.Relation.String()}
+	}
+	if maxScore := searchRequest.Hits.MaxScore; maxScore != nil {
+		score := float64(*maxScore)
+		result.MaxScore = &score
+	}
+	if len(searchRequest.Aggregations) > 0 {
+		result.Aggregations = make(map[string][]Bucket, len(searchRequest.Aggregations))
+		for name, aggregate := range searchRequest.Aggregations {
+			result.Aggregations[name] = aggregateBuckets(aggregate)
+		}
+	}
 	for index, hit := range searchRequest.Hits.Hits {
 		var item Item
 		if err := json.Unmarshal(hit.Source_, &item); err != nil {
 			return nil, item_errors.ParseErr
 		}
 		item.Id = *hit.Id_
-		result[index] = item
+		result.Items[index] = item
+
+		if len(hit.Highlight) > 0 {
+			if result.Highlights == nil {
+				result.Highlights = make(map[string]map[string][]string)
+			}
+			result.Highlights[item.Id] = hit.Highlight
+		}
+	}
+
+	if cursor != nil {
+		if err := d.nextCursor(ctx, cursor, searchRequest, pageSize, result); err != nil {
+			return nil, err
+		}
 	}
 
 	return result, nil
 }
 
-func (d *itemDaoStruct) Delete(ctx context.Context, id string) error {
-	itemFound, err := d.client.Delete(ctx, indexItems, id)
+// aggregateBuckets flattens the aggregate types EsQuery can ask for into buckets.
+func aggregateBuckets(aggregate types.Aggregate) []Bucket {
+	buckets := []Bucket{}
+	switch typed := aggregate.(type) {
+	case *types.StringTermsAggregate:
+		termsBuckets, _ := typed.Buckets.([]types.StringTermsBucket)
+		for _, bucket := range termsBuckets {
+			buckets = append(buckets, Bucket{Key: bucket.Key, DocCount: bucket.DocCount})
+		}
+	case *types.LongTermsAggregate:
+		termsBuckets, _ := typed.Buckets.([]types.LongTermsBucket)
+		for _, bucket := range termsBuckets {
+			buckets = append(buckets, Bucket{Key: bucket.Key, DocCount: bucket.DocCount})
+		}
+	case *types.HistogramAggregate:
+		histogramBuckets, _ := typed.Buckets.([]types.HistogramBucket)
+		for _, bucket := range histogramBuckets {
+			buckets = append(buckets, Bucket{Key: float64(bucket.Key), DocCount: bucket.DocCount})
+		}
+	case *types.RangeAggregate:
+		rangeBuckets, _ := typed.Buckets.([]types.RangeBucket)
+		for _, bucket := range rangeBuckets {
+			result := Bucket{DocCount: bucket.DocCount}
+			if bucket.Key != nil {
+				result.Key = *bucket.Key
+			}
+			if bucket.From != nil {
+				from := float64(*bucket.From)
+				result.From = &from
+			}
+			if bucket.To != nil {
+				to := float64(*bucket.To)
+				result.To = &to
+			}
+			buckets = append(buckets, result)
+		}
+	}
+	return buckets
+}
+
+// searchCursor returns the cursor the search continues from, opening a new point in time
+// when the client starts cursor pagination. Plain from/size searches get nil.
+func (d *itemDaoStruct) searchCursor(ctx context.Context, query queries.EsQuery) (*queries.Cursor, error) {
+	if query.Cursor != nil {
+		cursor, err := queries.DecodeCursor(*query.Cursor)
+		if err != nil || cursor.PitId == "" {
+			return nil, fmt.Errorf("%w: malformed cursor", item_errors.BadQueryErr)
+		}
+		return cursor, nil
+	}
+
+	if !query.UseCursor {
+		return nil, nil
+	}
+
+	pitId, err := d.client.OpenPointInTime(ctx, indexItems, queries.CursorKeepAlive)
+	if err != nil {
+		return nil, fmt.Errorf("open point in time failed %w", err)
+	}
+	return &queries.Cursor{PitId: pitId}, nil
+}
+
+// nextCursor sets the cursor of the following page, or releases the point in time once the last page is reached.
+func (d *itemDaoStruct) nextCursor(ctx context.Context, cursor *queries.Cursor, response *search.Response, pageSize int, result *SearchResult) error {
+	hits := response.Hits.Hits
+	if len(hits) < pageSize || len(hits) == 0 {
+		// the point in time expires anyway, failing to close it early is not worth failing the search
+		_ = d.client.ClosePointInTime(ctx, cursor.PitId)
+		return nil
+	}
+
+	next := queries.Cursor{PitId: cursor.PitId, SearchAfter: hits[len(hits)-1].Sort}
+	if response.PitId != nil {
+		next.PitId = *response.PitId
+	}
+	encoded, err := next.Encode()
 	if err != nil {
+		return fmt.Errorf("encode cursor failed %w", err)
+	}
+	result.NextCursor = encoded
+	return nil
+}
+
+// Delete soft deletes the item, it stays stored with deleted_at set until Purge removes it.
+func (d *itemDaoStruct) Delete(ctx context.Context, id string, version *elasticsearch.DocVersion) error {
+	now := time.Now().UTC()
+	return d.setDeleted(ctx, id, DeletionUpdate{DeletedAt: &now, DateUpdated: now}, version)
+}
+
+func (d *itemDaoStruct) Restore(ctx context.Context, id string, version *elasticsearch.DocVersion) error {
+	return d.setDeleted(ctx, id, DeletionUpdate{DateUpdated: time.Now().UTC()}, version)
+}
+
+func (d *itemDaoStruct) setDeleted(ctx context.Context, id string, update DeletionUpdate, version *elasticsearch.DocVersion) error {
+	updated, err := d.client.Update(ctx, indexItems, id, update, version)
+	if err != nil {
+		if errors.Is(err, item_errors.ConflictErr) {
+			return err
+		}
 		return fmt.Errorf("delete failed %w", err)
 	}
-	if !itemFound {
+	if updated == nil {
 		return item_errors.NotFoundErr
 	}
-	
+
 	return nil
 }
 
-func (d *itemDaoStruct) Put(ctx context.Context, item Item) error {
-	itemFound, err := d.client.Update(ctx, indexItems, item.Id, item)
+// Purge permanently removes the items soft deleted before the given time.
+func (d *itemDaoStruct) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	before := deletedBefore.UTC().Format(time.RFC3339Nano)
+	query := &types.Query{
+		Range: map[string]types.RangeQuery{
+			"deleted_at": types.DateRangeQuery{Lt: &before},
+		},
+	}
+
+	deleted, err := d.client.DeleteByQuery(ctx, indexItems, query)
+	if err != nil {
+		return 0, fmt.Errorf("purge failed %w", err)
+	}
+	return deleted, nil
+}
+
+func (d *itemDaoStruct) Put(ctx context.Context, item *Item) error {
+	version, err := d.client.Update(ctx, indexItems, item.Id, item, item.Version)
 	if err != nil {
+		if errors.Is(err, item_errors.ConflictErr) {
+			return err
+		}
 		return fmt.Errorf("update of entire item failed %w", err)
 	}
-	if !itemFound {
+	if version == nil {
 		return item_errors.NotFoundErr
 	}
+	item.Version = version
 
 	return nil
 }
 
 func (d *itemDaoStruct) Patch(ctx context.Context, partialUpdateItem PartialUpdateItem, id string) error {
-	itemFound, err := d.client.Update(ctx, indexItems, id, partialUpdateItem)
+	version, err := d.client.Update(ctx, indexItems, id, partialUpdateItem, partialUpdateItem.Version)
 	if err != nil {
-		
+		if errors.Is(err, item_errors.ConflictErr) {
+			return err
+		}
 		return fmt.Errorf("update item`s field(s) failed %w", err)
 	}
-	if !itemFound {
+	if version == nil {
+		return item_errors.NotFoundErr
+	}
+
+	return nil
+}
+
+func (d *itemDaoStruct) UpdateStock(ctx context.Context, id string, operation StockOperation, request StockRequest) error {
+	params := map[string]any{
+		"operation":        operation,
+		"quantity":         request.Quantity,
+		"from_reservation": request.FromReservation,
+		"sold_out_status":  StatusSoldOut,
+		"active_status":    StatusActive,
+		"now":              time.Now().UTC(),
+	}
+
+	res, err := d.client.UpdateByScript(ctx, indexItems, id, stockScript, params)
+	if err != nil {
+		return fmt.Errorf("%s of item stock failed %w", operation, err)
+	}
+
+	switch res {
+	case result.Notfound:
 		return item_errors.NotFoundErr
+	case result.Noop:
+		return item_errors.OutOfStockErr
+	}
+
+	return nil
+}
+
+func (d *itemDaoStruct) GetMany(ctx context.Context, ids []string) (map[string]*Item, error) {
+	found := make(map[string]*Item, len(ids))
+	if len(ids) == 0 {
+		return found, nil
+	}
+
+	docs, err := d.client.MultiGet(ctx, indexItems, ids)
+	if err != nil {
+		return nil, fmt.Errorf("multi get failed %w", err)
+	}
+
+	for _, doc := range docs {
+		var item Item
+		if err := json.Unmarshal(doc.Source_, &item); err != nil {
+			return nil, item_errors.ParseErr
+		}
+		if item.DeletedAt != nil {
+			continue
+		}
+		item.Id = doc.Id_
+		item.Version = elasticsearch.NewDocVersion(doc.SeqNo_, doc.PrimaryTerm_)
+		found[item.Id] = &item
+	}
+
+	return found, nil
+}
+
+func (d *itemDaoStruct) Bulk(ctx context.Context, operations []elasticsearch.BulkOperation) ([]elasticsearch.BulkItemResult, error) {
+	results, err := d.client.Bulk(ctx, indexItems, operations)
+	if err != nil {
+		return nil, fmt.Errorf("bulk failed %w", err)
+	}
+
+	return results, nil
+}
+
+func (d *itemDaoStruct) Export(ctx context.Context, seller int64, handle func([]Item) error) error {
+	query := &types.Query{
+		Bool: &types.BoolQuery{
+			Filter: []types.Query{{
+				Term: map[string]types.TermQuery{
+					"seller": {Value: seller},
+				},
+			}},
+			MustNot: []types.Query{queries.Deleted()},
+		},
+	}
+
+	err := d.client.ScrollAll(ctx, indexItems, query, exportBatchSize, func(hits []types.Hit) error {
+		batch := make([]Item, len(hits))
+		for index, hit := range hits {
+			if err := json.Unmarshal(hit.Source_, &batch[index]); err != nil {
+				return item_errors.ParseErr
+			}
+			batch[index].Id = *hit.Id_
+		}
+		return handle(batch)
+	})
+	if err != nil {
+		return fmt.Errorf("export failed %w", err)
 	}
 
 	return nil
+}
+
+// Suggest returns up to size distinct titles starting with the typed text, matched against
+// the search_as_you_type sub-field of title and its shingles.
+func (d *itemDaoStruct) Suggest(ctx context.Context, text string, size int) ([]string, error) {
+	queryType := textquerytype.Boolprefix
+	request := &search.Request{
+		Query: &types.Query{
+			Bool: &types.BoolQuery{
+				Must: []types.Query{{
+					MultiMatch: &types.MultiMatchQuery{
+						Query:  text,
+						Type:   &queryType,
+						Fields: []string{"title.suggest", "title.suggest._2gram", "title.suggest._3gram"},
+					},
+				}},
+				MustNot: []types.Query{queries.Deleted()},
+			},
+		},
+		Size:    &size,
+		Source_: types.SourceFilter{Includes: []string{"title"}},
+	}
+
+	searchRequest, err := d.client.Search(ctx, indexItems, request)
+	if err != nil {
+		return nil, fmt.Errorf("suggest failed %w", err)
+	}
+
+	titles := make([]string, 0, len(searchRequest.Hits.Hits))
+	for _, hit := range searchRequest.Hits.Hits {
+		var item Item
+		if err := json.Unmarshal(hit.Source_, &item); err != nil {
+			return nil, item_errors.ParseErr
+		}
+		if item.Title != "" && !slices.Contains(titles, item.Title) {
+			titles = append(titles, item.Title)
+		}
+	}
+
+	return titles, nil
 }
\ No newline at end of file
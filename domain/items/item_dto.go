@@ -1,5 +1,13 @@
 package items
 
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/SerhiiKhyzhko/bookstore_items-api/clients/elasticsearch"
+	"github.com/SerhiiKhyzhko/bookstore_items-api/item_errors"
+)
+
 type Item struct {
 	Id                string      `json:"id"`
 	Seller            int64       `json:"seller"`
@@ -10,7 +18,15 @@ type Item struct {
 	Price             float32     `json:"price"`
 	AvailableQuantity int         `json:"available_quantity"`
 	SoldQuantity      int         `json:"sold_quantity"`
+	ReservedQuantity  int         `json:"reserved_quantity"`
 	Status            string      `json:"status"`
+	DateCreated       time.Time   `json:"date_created"`
+	DateUpdated       time.Time   `json:"date_updated"`
+	// set while the item is soft deleted, purged for good once the retention period is over
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+
+	// revision the item was read at, exposed to clients as an ETag
+	Version *elasticsearch.DocVersion `json:"-"`
 }
 
 type PartialUpdateItem struct {
@@ -22,6 +38,93 @@ type PartialUpdateItem struct {
 	AvailableQuantity *int               `json:"available_quantity,omitempty"`
 	SoldQuantity      *int               `json:"sold_quantity,omitempty"`
 	Status            *string            `json:"status,omitempty"`
+	DateUpdated       *time.Time         `json:"date_updated,omitempty"`
+
+	// when set, the update only applies if the item is still at this revision
+	Version *elasticsearch.DocVersion `json:"-"`
+}
+
+// DeletionUpdate soft deletes an item or restores it, DeletedAt is written even when nil
+// so a restore clears the stored timestamp.
+type DeletionUpdate struct {
+	DeletedAt   *time.Time `json:"deleted_at"`
+	DateUpdated time.Time  `json:"date_updated"`
+}
+
+type StockOperation string
+
+const (
+	StockPurchase StockOperation = "purchase"
+	StockReserve  StockOperation = "reserve"
+	StockRelease  StockOperation = "release"
+)
+
+// SearchResult is a page of search hits, NextCursor is set while cursor pagination has more pages.
+type SearchResult struct {
+	Total      SearchTotal `json:"total"`
+	TookMs     int64       `json:"took_ms"`
+	From       int         `json:"from"`
+	Size       int         `json:"size"`
+	MaxScore   *float64    `json:"max_score"`
+	Items      []Item      `json:"items"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+
+	Aggregations map[string][]Bucket `json:"aggregations,omitempty"`
+	// highlighted fragments per item id and field, the items themselves keep their stored values
+	Highlights map[string]map[string][]string `json:"highlights,omitempty"`
+}
+
+// Bucket is one entry of an aggregation: a status, seller, histogram step or range with its item count.
+type Bucket struct {
+	Key      any      `json:"key"`
+	From     *float64 `json:"from,omitempty"`
+	To       *float64 `json:"to,omitempty"`
+	DocCount int64    `json:"doc_count"`
+}
+
+// SearchTotal is the number of matching items, Relation is "gte" when elasticsearch
+// stopped counting and Value is only a lower bound.
+type SearchTotal struct {
+	Value    int64  `json:"value"`
+	Relation string `json:"relation"`
+}
+
+// StockRequest is the body of the purchase, reserve and release endpoints.
+type StockRequest struct {
+	Quantity int `json:"quantity"`
+	// purchase items previously put aside with reserve instead of the available stock
+	FromReservation bool `json:"from_reservation"`
+}
+
+const (
+	BulkCreate = "create"
+	BulkUpdate = "update"
+	BulkDelete = "delete"
+)
+
+// BulkAction is one NDJSON line of a bulk request. Item holds a full item for create
+// and the changed fields for update, Id is required for update and delete.
+type BulkAction struct {
+	Action string          `json:"action"`
+	Id     string          `json:"id,omitempty"`
+	Item   json.RawMessage `json:"item,omitempty"`
+
+	Line int `json:"-"`
+}
+
+type BulkResult struct {
+	Line   int    `json:"line"`
+	Action string `json:"action"`
+	Id     string `json:"id,omitempty"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+
+	FieldErrors []item_errors.FieldError `json:"field_errors,omitempty"`
+}
+
+type BulkResponse struct {
+	Errors bool         `json:"errors"`
+	Items  []BulkResult `json:"items"`
 }
 
 type Description struct {
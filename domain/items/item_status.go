@@ -0,0 +1,64 @@
+package items
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/SerhiiKhyzhko/bookstore_items-api/item_errors"
+)
+
+// An item starts as a draft or goes live right away, sells while active, and ends archived.
+// sold_out is mostly reached by the stock updates, archived items can't be changed any more.
+const (
+	StatusDraft    = "draft"
+	StatusActive   = "active"
+	StatusPaused   = "paused"
+	StatusSoldOut  = "sold_out"
+	StatusArchived = "archived"
+)
+
+// Statuses lists every status of the lifecycle.
+var Statuses = []string{StatusDraft, StatusActive, StatusPaused, StatusSoldOut, StatusArchived}
+
+// initialStatuses are the statuses an item can be created with.
+var initialStatuses = []string{StatusDraft, StatusActive}
+
+// transitions lists for every status the statuses it may move to.
+var transitions = map[string][]string{
+	StatusDraft:    {StatusActive, StatusArchived},
+	StatusActive:   {StatusPaused, StatusSoldOut, StatusArchived},
+	StatusPaused:   {StatusActive, StatusArchived},
+	StatusSoldOut:  {StatusActive, StatusArchived},
+	StatusArchived: {},
+}
+
+func IsValidStatus(status string) bool {
+	return slices.Contains(Statuses, status)
+}
+
+// CanTransition tells whether an item may move from one status to another, staying put is always allowed.
+func CanTransition(from string, to string) bool {
+	return from == to || slices.Contains(transitions[from], to)
+}
+
+// CheckTransition returns an error wrapping item_errors.IllegalTransitionErr when the move is not allowed.
+func CheckTransition(from string, to string) error {
+	if !CanTransition(from, to) {
+		return fmt.Errorf("%w: cannot move item from %s to %s", item_errors.IllegalTransitionErr, from, to)
+	}
+	return nil
+}
+
+// CheckUpdate is CheckTransition for edits of the item, which are refused altogether once it is archived.
+func CheckUpdate(from string, to string) error {
+	if from == StatusArchived {
+		return fmt.Errorf("%w: archived items can't be changed", item_errors.IllegalTransitionErr)
+	}
+	return CheckTransition(from, to)
+}
+
+// AcceptsOrders tells whether items can be purchased or reserved in the status,
+// sold out items still sell what was reserved before.
+func AcceptsOrders(status string) bool {
+	return status == StatusActive || status == StatusSoldOut
+}
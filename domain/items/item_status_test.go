@@ -0,0 +1,92 @@
+package items
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/SerhiiKhyzhko/bookstore_items-api/item_errors"
+)
+
+func TestCheckTransition(t *testing.T) {
+	tests := []struct {
+		from    string
+		to      string
+		allowed bool
+	}{
+		{StatusDraft, StatusDraft, true},
+		{StatusDraft, StatusActive, true},
+		{StatusDraft, StatusArchived, true},
+		{StatusDraft, StatusPaused, false},
+		{StatusDraft, StatusSoldOut, false},
+		{StatusActive, StatusPaused, true},
+		{StatusActive, StatusSoldOut, true},
+		{StatusActive, StatusArchived, true},
+		{StatusActive, StatusDraft, false},
+		{StatusPaused, StatusActive, true},
+		{StatusPaused, StatusArchived, true},
+		{StatusPaused, StatusSoldOut, false},
+		{StatusSoldOut, StatusActive, true},
+		{StatusSoldOut, StatusArchived, true},
+		{StatusSoldOut, StatusPaused, false},
+		{StatusArchived, StatusArchived, true},
+		{StatusArchived, StatusActive, false},
+		{StatusArchived, StatusDraft, false},
+	}
+	for _, test := range tests {
+		t.Run(test.from+"->"+test.to, func(t *testing.T) {
+			err := CheckTransition(test.from, test.to)
+			if test.allowed && err != nil {
+				t.Errorf("CheckTransition() = %v, want nil", err)
+			}
+			if !test.allowed && !errors.Is(err, item_errors.IllegalTransitionErr) {
+				t.Errorf("CheckTransition() = %v, want %v", err, item_errors.IllegalTransitionErr)
+			}
+		})
+	}
+}
+
+func TestCheckUpdate(t *testing.T) {
+	tests := []struct {
+		from    string
+		to      string
+		allowed bool
+	}{
+		{StatusDraft, StatusDraft, true},
+		{StatusActive, StatusPaused, true},
+		{StatusSoldOut, StatusSoldOut, true},
+		{StatusPaused, StatusSoldOut, false},
+		{StatusArchived, StatusArchived, false},
+		{StatusArchived, StatusActive, false},
+	}
+	for _, test := range tests {
+		t.Run(test.from+"->"+test.to, func(t *testing.T) {
+			err := CheckUpdate(test.from, test.to)
+			if test.allowed && err != nil {
+				t.Errorf("CheckUpdate() = %v, want nil", err)
+			}
+			if !test.allowed && !errors.Is(err, item_errors.IllegalTransitionErr) {
+				t.Errorf("CheckUpdate() = %v, want %v", err, item_errors.IllegalTransitionErr)
+			}
+		})
+	}
+}
+
+func TestAcceptsOrders(t *testing.T) {
+	tests := []struct {
+		status string
+		want   bool
+	}{
+		{StatusDraft, false},
+		{StatusActive, true},
+		{StatusPaused, false},
+		{StatusSoldOut, true},
+		{StatusArchived, false},
+	}
+	for _, test := range tests {
+		t.Run(test.status, func(t *testing.T) {
+			if got := AcceptsOrders(test.status); got != test.want {
+				t.Errorf("AcceptsOrders(%q) = %t, want %t", test.status, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package items
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/SerhiiKhyzhko/bookstore_items-api/item_errors"
+)
+
+const (
+	maxTitleLength = 200
+	maxPictures    = 20
+
+	CodeRequired     = "required"
+	CodeTooLong      = "too_long"
+	CodeTooMany      = "too_many"
+	CodeNegative     = "negative"
+	CodeInvalid      = "invalid"
+	CodeInvalidUrl   = "invalid_url"
+	CodeExceedsStock = "exceeds_stock"
+)
+
+// validator collects field errors so a client gets every problem of an item in one response.
+type validator struct {
+	fields []item_errors.FieldError
+}
+
+func (v *validator) add(field string, code string, message string) {
+	v.fields = append(v.fields, item_errors.FieldError{Field: field, Code: code, Message: message})
+}
+
+func (v *validator) err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return &item_errors.ValidationError{Fields: v.fields}
+}
+
+func (v *validator) title(title string) {
+	switch {
+	case strings.TrimSpace(title) == "":
+		v.add("title", CodeRequired, "title is required")
+	case utf8.RuneCountInString(title) > maxTitleLength:
+		v.add("title", CodeTooLong, fmt.Sprintf("title must be at most %d characters", maxTitleLength))
+	}
+}
+
+func (v *validator) price(price float32) {
+	switch {
+	case math.IsNaN(float64(price)) || math.IsInf(float64(price), 0):
+		v.add("price", CodeInvalid, "price must be a finite number")
+	case price < 0:
+		v.add("price", CodeNegative, "price must not be negative")
+	}
+}
+
+func (v *validator) quantity(field string, quantity int) {
+	if quantity < 0 {
+		v.add(field, CodeNegative, field+" must not be negative")
+	}
+}
+
+func (v *validator) status(status string, allowed []string) {
+	if !slices.Contains(allowed, status) {
+		v.add("status", CodeInvalid, fmt.Sprintf("status must be one of %s", strings.Join(allowed, ", ")))
+	}
+}
+
+func (v *validator) url(field string, value string) {
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		v.add(field, CodeInvalidUrl, field+" must be an absolute http(s) url")
+	}
+}
+
+func (v *validator) pictureCount(count int) {
+	if count > maxPictures {
+		v.add("pictures", CodeTooMany, fmt.Sprintf("an item can have at most %d pictures", maxPictures))
+	}
+}
+
+// Validate checks a full item before it is replaced, whether the status change is allowed
+// depends on the stored item and is checked with CheckTransition.
+func (item *Item) Validate() error {
+	v := &validator{}
+	item.validate(v)
+	v.status(item.Status, Statuses)
+	return v.err()
+}
+
+// ValidateNew additionally checks rules that only hold for an item that was never sold through the API:
+// it starts as a draft or active, and the sold quantity is taken from the seller as is,
+// so it can't exceed the stock they put up.
+func (item *Item) ValidateNew() error {
+	v := &validator{}
+	item.validate(v)
+	v.status(item.Status, initialStatuses)
+	if item.SoldQuantity > item.AvailableQuantity {
+		v.add("sold_quantity", CodeExceedsStock, "sold_quantity must not exceed available_quantity")
+	}
+	return v.err()
+}
+
+func (item *Item) validate(v *validator) {
+	v.title(item.Title)
+	v.price(item.Price)
+	v.quantity("available_quantity", item.AvailableQuantity)
+	v.quantity("sold_quantity", item.SoldQuantity)
+	if item.Video != "" {
+		v.url("video", item.Video)
+	}
+	v.pictureCount(len(item.Pictures))
+	for index, picture := range item.Pictures {
+		v.url(fmt.Sprintf("pictures[%d].url", index), picture.Url)
+	}
+}
+
+// Validate checks the fields a partial update sets, absent fields keep their stored values.
+func (item *PartialUpdateItem) Validate() error {
+	v := &validator{}
+	if item.Title != nil {
+		v.title(*item.Title)
+	}
+	if item.Price != nil {
+		v.price(*item.Price)
+	}
+	if item.AvailableQuantity != nil {
+		v.quantity("available_quantity", *item.AvailableQuantity)
+	}
+	if item.SoldQuantity != nil {
+		v.quantity("sold_quantity", *item.SoldQuantity)
+	}
+	if item.Status != nil {
+		v.status(*item.Status, Statuses)
+	}
+	if item.Video != nil && *item.Video != "" {
+		v.url("video", *item.Video)
+	}
+	if item.Pictures != nil {
+		v.pictureCount(len(*item.Pictures))
+		for index, picture := range *item.Pictures {
+			field := fmt.Sprintf("pictures[%d].url", index)
+			if picture.Url == nil {
+				v.add(field, CodeRequired, field+" is required")
+				continue
+			}
+			v.url(field, *picture.Url)
+		}
+	}
+	return v.err()
+}
@@ -0,0 +1,133 @@
+package items
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/SerhiiKhyzhko/bookstore_items-api/item_errors"
+)
+
+// fieldCodes flattens a validation error to "field:code" pairs.
+func fieldCodes(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var validationErr *item_errors.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("err = %v, want a *item_errors.ValidationError", err)
+	}
+	codes := make([]string, 0, len(validationErr.Fields))
+	for _, field := range validationErr.Fields {
+		codes = append(codes, field.Field+":"+field.Code)
+	}
+	return codes
+}
+
+func validItem() Item {
+	return Item{
+		Title:             "Go in Action",
+		Price:             25.5,
+		AvailableQuantity: 10,
+		SoldQuantity:      2,
+		Status:            StatusActive,
+		Video:             "https://example.com/video.mp4",
+		Pictures:          []Pictures{{Id: 1, Url: "https://example.com/cover.png"}},
+	}
+}
+
+func TestItemValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(item *Item)
+		want   []string
+	}{
+		{"valid", func(item *Item) {}, nil},
+		{"blank title", func(item *Item) { item.Title = "  " }, []string{"title:required"}},
+		{"title too long", func(item *Item) { item.Title = strings.Repeat("ü", maxTitleLength+1) }, []string{"title:too_long"}},
+		{"title at the limit", func(item *Item) { item.Title = strings.Repeat("ü", maxTitleLength) }, nil},
+		{"negative price", func(item *Item) { item.Price = -1 }, []string{"price:negative"}},
+		{"nan price", func(item *Item) { item.Price = float32(math.NaN()) }, []string{"price:invalid"}},
+		{"infinite price", func(item *Item) { item.Price = float32(math.Inf(1)) }, []string{"price:invalid"}},
+		{"negative quantities", func(item *Item) { item.AvailableQuantity, item.SoldQuantity = -1, -1 },
+			[]string{"available_quantity:negative", "sold_quantity:negative"}},
+		{"sold above stock is fine for stored items", func(item *Item) { item.SoldQuantity = 20 }, nil},
+		{"any lifecycle status", func(item *Item) { item.Status = StatusArchived }, nil},
+		{"unknown status", func(item *Item) { item.Status = "deleted" }, []string{"status:invalid"}},
+		{"no video", func(item *Item) { item.Video = "" }, nil},
+		{"relative video url", func(item *Item) { item.Video = "/video.mp4" }, []string{"video:invalid_url"}},
+		{"ftp picture url", func(item *Item) { item.Pictures[0].Url = "ftp://example.com/cover.png" }, []string{"pictures[0].url:invalid_url"}},
+		{"too many pictures", func(item *Item) {
+			item.Pictures = slices.Repeat(item.Pictures, maxPictures+1)
+		}, []string{"pictures:too_many"}},
+		{"every problem at once", func(item *Item) { item.Title, item.Price, item.Status = "", -1, "" },
+			[]string{"title:required", "price:negative", "status:invalid"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			item := validItem()
+			test.modify(&item)
+			err := item.Validate()
+			if got := fieldCodes(t, err); !slices.Equal(got, test.want) {
+				t.Errorf("Validate() fields = %v, want %v", got, test.want)
+			}
+			if test.want != nil && !errors.Is(err, item_errors.ValidationErr) {
+				t.Errorf("Validate() = %v, want it to match %v", err, item_errors.ValidationErr)
+			}
+		})
+	}
+}
+
+func TestItemValidateNew(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(item *Item)
+		want   []string
+	}{
+		{"active", func(item *Item) {}, nil},
+		{"draft", func(item *Item) { item.Status = StatusDraft }, nil},
+		{"paused", func(item *Item) { item.Status = StatusPaused }, []string{"status:invalid"}},
+		{"archived", func(item *Item) { item.Status = StatusArchived }, []string{"status:invalid"}},
+		{"sold everything", func(item *Item) { item.SoldQuantity = item.AvailableQuantity }, nil},
+		{"sold above stock", func(item *Item) { item.SoldQuantity = item.AvailableQuantity + 1 }, []string{"sold_quantity:exceeds_stock"}},
+		{"shared rules still apply", func(item *Item) { item.Title = "" }, []string{"title:required"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			item := validItem()
+			test.modify(&item)
+			if got := fieldCodes(t, item.ValidateNew()); !slices.Equal(got, test.want) {
+				t.Errorf("ValidateNew() fields = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPartialUpdateItemValidate(t *testing.T) {
+	ptr := func(s string) *string { return &s }
+	negative := -1
+	tests := []struct {
+		name string
+		item PartialUpdateItem
+		want []string
+	}{
+		{"nothing set", PartialUpdateItem{}, nil},
+		{"valid fields", PartialUpdateItem{Title: ptr("Go"), Status: ptr(StatusPaused), Video: ptr("")}, nil},
+		{"blank title", PartialUpdateItem{Title: ptr("")}, []string{"title:required"}},
+		{"negative stock", PartialUpdateItem{AvailableQuantity: &negative}, []string{"available_quantity:negative"}},
+		{"unknown status", PartialUpdateItem{Status: ptr("gone")}, []string{"status:invalid"}},
+		{"invalid video", PartialUpdateItem{Video: ptr("video")}, []string{"video:invalid_url"}},
+		{"pictures", PartialUpdateItem{Pictures: &[]UpdatePictures{{Url: ptr("https://example.com/a.png")}, {}, {Url: ptr("a.png")}}},
+			[]string{"pictures[1].url:required", "pictures[2].url:invalid_url"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := fieldCodes(t, test.item.Validate()); !slices.Equal(got, test.want) {
+				t.Errorf("Validate() fields = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package queries
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/elastic/go-elasticsearch/v9/typedapi/types"
+)
+
+const (
+	// CursorKeepAlive is how long a cursor stays usable between two page requests
+	CursorKeepAlive = "1m"
+	DefaultPageSize = 10
+)
+
+// Cursor points right after the last hit of a page: the point in time the search runs
+// against and the sort values of that hit. Clients only ever see it encoded.
+type Cursor struct {
+	PitId       string             `json:"pit"`
+	SearchAfter []types.FieldValue `json:"after,omitempty"`
+}
+
+func (c Cursor) Encode() (string, error) {
+	raw, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func DecodeCursor(encoded string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor Cursor
+	// sort values may be longs, keep them exact instead of going through float64
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
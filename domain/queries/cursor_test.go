@@ -0,0 +1,69 @@
+package queries
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v9/typedapi/types"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor Cursor
+		want   Cursor
+	}{
+		{
+			name:   "first page",
+			cursor: Cursor{PitId: "pit-1"},
+			want:   Cursor{PitId: "pit-1"},
+		},
+		{
+			name:   "sort values",
+			cursor: Cursor{PitId: "pit-1", SearchAfter: []types.FieldValue{"book", 12.5, true}},
+			want:   Cursor{PitId: "pit-1", SearchAfter: []types.FieldValue{"book", json.Number("12.5"), true}},
+		},
+		{
+			// shard doc tiebreakers are longs that don't fit a float64 exactly
+			name:   "long sort values keep their precision",
+			cursor: Cursor{PitId: "pit-1", SearchAfter: []types.FieldValue{int64(9007199254740993)}},
+			want:   Cursor{PitId: "pit-1", SearchAfter: []types.FieldValue{json.Number("9007199254740993")}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoded, err := test.cursor.Encode()
+			if err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			decoded, err := DecodeCursor(encoded)
+			if err != nil {
+				t.Fatalf("DecodeCursor() error = %v", err)
+			}
+			if !reflect.DeepEqual(*decoded, test.want) {
+				t.Errorf("DecodeCursor() = %#v, want %#v", *decoded, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"not base64", "not a cursor!"},
+		{"not json", base64.RawURLEncoding.EncodeToString([]byte("pit-1"))},
+		{"wrong shape", base64.RawURLEncoding.EncodeToString([]byte(`{"pit":1}`))},
+		{"empty", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if cursor, err := DecodeCursor(test.encoded); err == nil {
+				t.Errorf("DecodeCursor(%q) = %+v, want an error", test.encoded, cursor)
+			}
+		})
+	}
+}
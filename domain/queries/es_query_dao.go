@@ -1,19 +1,71 @@
 package queries
 
 import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/SerhiiKhyzhko/bookstore_items-api/item_errors"
 	"github.com/elastic/go-elasticsearch/v9/typedapi/types"
+	"github.com/elastic/go-elasticsearch/v9/typedapi/types/enums/highlighterencoder"
+	"github.com/elastic/go-elasticsearch/v9/typedapi/types/enums/operator"
+	"github.com/elastic/go-elasticsearch/v9/typedapi/types/enums/sortorder"
+)
+
+const (
+	AggregationTerms     = "terms"
+	AggregationRange     = "range"
+	AggregationHistogram = "histogram"
+
+	maxAggregations     = 10
+	maxTermsBuckets     = 100
+	defaultTermsBuckets = 10
 )
 
+// aggregatableFields lists the aggregation types each field supports
+var aggregatableFields = map[string][]string{
+	"status":             {AggregationTerms},
+	"seller":             {AggregationTerms},
+	"price":              {AggregationRange, AggregationHistogram},
+	"available_quantity": {AggregationRange, AggregationHistogram},
+}
+
+// sortableFields are the fields results can be ordered by, mapped to their default direction
+var sortableFields = map[string]sortorder.SortOrder{
+	"_score":             sortorder.Desc,
+	"price":              sortorder.Asc,
+	"date_created":       sortorder.Desc,
+	"date_updated":       sortorder.Desc,
+	"sold_quantity":      sortorder.Desc,
+	"available_quantity": sortorder.Desc,
+}
+
+const defaultFuzziness = "AUTO"
+
+// textFields are the fields search_text is matched against, title matches outrank description ones.
+// description is an object, its text lives in plain_text and in html analyzed without the markup.
+var textFields = []string{"title^3", "description.plain_text", "description.html"}
+
+// fuzzinessPattern accepts the fuzziness values elasticsearch understands for text fields
+var fuzzinessPattern = regexp.MustCompile(`^(0|1|2|AUTO|AUTO:\d+,\d+)$`)
+
+// highlightFields are the text fields search_text matches are highlighted in
+var highlightFields = []string{"title", "description.plain_text"}
+
+// Deleted matches soft deleted items, every search excludes them unless IncludeDeleted is set.
+func Deleted() types.Query {
+	return types.Query{Exists: &types.ExistsQuery{Field: "deleted_at"}}
+}
+
 func (q *EsQuery) Build() *types.Query {
 	queries := []types.Query{}
 	filters := []types.Query{}
 
 	if q.SearchText != nil && *q.SearchText != "" {
 		queries = append(queries, types.Query{
-			MultiMatch: &types.MultiMatchQuery{
-				Query: *q.SearchText,
-				Fields: []string{"title", "description"},
-			},
+			MultiMatch: q.buildTextMatch(),
 		})
 	}
 
@@ -65,10 +117,210 @@ func (q *EsQuery) Build() *types.Query {
 		})
 	}
 
+	var excluded []types.Query
+	if !q.IncludeDeleted {
+		excluded = append(excluded, Deleted())
+	}
+
 	return &types.Query{
 		Bool: &types.BoolQuery{
 			Must: queries,
 			Filter: filters,
+			MustNot: excluded,
 		},
 	}
+}
+
+func (q *EsQuery) Validate() error {
+	if q.Size != nil && *q.Size < 0 {
+		return fmt.Errorf("%w: size can not be negative", item_errors.BadQueryErr)
+	}
+	if q.From != nil && *q.From < 0 {
+		return fmt.Errorf("%w: from can not be negative", item_errors.BadQueryErr)
+	}
+	if (q.UseCursor || q.Cursor != nil) && q.From != nil {
+		return fmt.Errorf("%w: from can not be combined with cursor pagination", item_errors.BadQueryErr)
+	}
+	for _, rawSort := range q.Sort {
+		if _, _, err := parseSort(rawSort); err != nil {
+			return err
+		}
+	}
+
+	if q.Fuzziness != nil && !fuzzinessPattern.MatchString(*q.Fuzziness) {
+		return fmt.Errorf("%w: fuzziness must be 0, 1, 2, AUTO or AUTO:low,high", item_errors.BadQueryErr)
+	}
+	if q.Operator != nil && *q.Operator != "and" && *q.Operator != "or" {
+		return fmt.Errorf("%w: operator must be and or or", item_errors.BadQueryErr)
+	}
+
+	if len(q.Aggregations) > maxAggregations {
+		return fmt.Errorf("%w: at most %d aggregations are allowed", item_errors.BadQueryErr, maxAggregations)
+	}
+	for name, aggregation := range q.Aggregations {
+		if err := aggregation.validate(); err != nil {
+			return fmt.Errorf("%w: aggregation %q %s", item_errors.BadQueryErr, name, err.Error())
+		}
+	}
+	return nil
+}
+
+// buildTextMatch matches search_text against the text fields, tolerating typos unless fuzziness is turned off.
+func (q *EsQuery) buildTextMatch() *types.MultiMatchQuery {
+	match := &types.MultiMatchQuery{
+		Query:     *q.SearchText,
+		Fields:    textFields,
+		Fuzziness: defaultFuzziness,
+	}
+	if q.Fuzziness != nil {
+		match.Fuzziness = *q.Fuzziness
+	}
+	if q.Operator != nil {
+		textOperator := operator.Or
+		if *q.Operator == "and" {
+			textOperator = operator.And
+		}
+		match.Operator = &textOperator
+	}
+	if q.MinimumShouldMatch != nil {
+		match.MinimumShouldMatch = *q.MinimumShouldMatch
+	}
+	return match
+}
+
+func (a Aggregation) validate() error {
+	supported, found := aggregatableFields[a.Field]
+	if !found {
+		return fmt.Errorf("can not aggregate on field %q", a.Field)
+	}
+	if !slices.Contains(supported, a.Type) {
+		return fmt.Errorf("field %q supports %s aggregations only", a.Field, strings.Join(supported, ", "))
+	}
+
+	switch a.Type {
+	case AggregationTerms:
+		if a.Size != nil && (*a.Size <= 0 || *a.Size > maxTermsBuckets) {
+			return fmt.Errorf("size must be between 1 and %d", maxTermsBuckets)
+		}
+	case AggregationHistogram:
+		if a.Interval == nil || *a.Interval <= 0 {
+			return errors.New("interval must be positive")
+		}
+	case AggregationRange:
+		if len(a.Ranges) == 0 {
+			return errors.New("at least one range is required")
+		}
+	}
+	return nil
+}
+
+// BuildAggregations turns Aggregations into elasticsearch aggregations,
+// they are expected to be checked by Validate beforehand.
+func (q *EsQuery) BuildAggregations() map[string]types.Aggregations {
+	if len(q.Aggregations) == 0 {
+		return nil
+	}
+
+	aggregations := make(map[string]types.Aggregations, len(q.Aggregations))
+	for name, aggregation := range q.Aggregations {
+		field := aggregation.Field
+		switch aggregation.Type {
+		case AggregationTerms:
+			size := defaultTermsBuckets
+			if aggregation.Size != nil {
+				size = *aggregation.Size
+			}
+			aggregations[name] = types.Aggregations{
+				Terms: &types.TermsAggregation{Field: &field, Size: &size},
+			}
+		case AggregationHistogram:
+			interval := types.Float64(*aggregation.Interval)
+			aggregations[name] = types.Aggregations{
+				Histogram: &types.HistogramAggregation{Field: &field, Interval: &interval},
+			}
+		case AggregationRange:
+			ranges := make([]types.AggregationRange, len(aggregation.Ranges))
+			for index, numericRange := range aggregation.Ranges {
+				ranges[index].Key = numericRange.Key
+				if numericRange.From != nil {
+					from := types.Float64(*numericRange.From)
+					ranges[index].From = &from
+				}
+				if numericRange.To != nil {
+					to := types.Float64(*numericRange.To)
+					ranges[index].To = &to
+				}
+			}
+			aggregations[name] = types.Aggregations{
+				Range: &types.RangeAggregation{Field: &field, Ranges: ranges},
+			}
+		}
+	}
+	return aggregations
+}
+
+// BuildSort turns the "field:direction" entries of Sort into elasticsearch sort options,
+// entries are expected to be checked by Validate beforehand.
+func (q *EsQuery) BuildSort() []types.SortCombinations {
+	var sorts []types.SortCombinations
+	for _, rawSort := range q.Sort {
+		field, order, err := parseSort(rawSort)
+		if err != nil {
+			continue
+		}
+
+		if field == "_score" {
+			sorts = append(sorts, types.SortOptions{Score_: &types.ScoreSort{Order: &order}})
+			continue
+		}
+		sorts = append(sorts, types.SortOptions{
+			SortOptions: map[string]types.FieldSort{field: {Order: &order}},
+		})
+	}
+	return sorts
+}
+
+func parseSort(rawSort string) (string, sortorder.SortOrder, error) {
+	field, direction, hasDirection := strings.Cut(strings.TrimSpace(rawSort), ":")
+	order, found := sortableFields[field]
+	if !found {
+		return "", order, fmt.Errorf("%w: can not sort by %q", item_errors.BadQueryErr, field)
+	}
+
+	if hasDirection {
+		switch direction {
+		case "asc":
+			order = sortorder.Asc
+		case "desc":
+			order = sortorder.Desc
+		default:
+			return "", order, fmt.Errorf("%w: sort direction of %q must be asc or desc", item_errors.BadQueryErr, field)
+		}
+	}
+	return field, order, nil
+}
+
+// PageSize is the number of hits a page holds, elasticsearch defaults to 10 when size is not set.
+func (q *EsQuery) PageSize() int {
+	if q.Size == nil {
+		return DefaultPageSize
+	}
+	return *q.Size
+}
+
+
+// BuildHighlight asks elasticsearch for fragments of the fields search_text matched,
+// there is nothing to highlight without search text.
+func (q *EsQuery) BuildHighlight() *types.Highlight {
+	if !q.Highlight || q.SearchText == nil || *q.SearchText == "" {
+		return nil
+	}
+
+	// fragments are rendered by the storefront, stored markup must come back escaped
+	encoder := highlighterencoder.Html
+	highlight := &types.Highlight{Encoder: &encoder}
+	for _, field := range highlightFields {
+		highlight.Fields = append(highlight.Fields, map[string]types.HighlightField{field: {}})
+	}
+	return highlight
 }
\ No newline at end of file
@@ -7,8 +7,41 @@ type EsQuery struct {
 	MinPrice          *float64 `json:"min_price"`
 	MaxPrice          *float64 `json:"max_price"`
 	AvailableQuantity *int     `json:"available_quantity"`
+	// Сортування: "поле:напрямок", наприклад "price:asc" або "_score"
+	Sort []string `json:"sort"`
+	// Налаштування повнотекстового пошуку: fuzziness ("AUTO" за замовчуванням, "0" вимикає),
+	// operator ("and"/"or") та minimum_should_match (наприклад "75%")
+	Fuzziness          *string `json:"fuzziness"`
+	Operator           *string `json:"operator"`
+	MinimumShouldMatch *string `json:"minimum_should_match"`
+	// Підсвічування збігів search_text у title та description.plain_text
+	Highlight bool `json:"highlight"`
+	// Агрегації для фільтрів вітрини: назва агрегації -> опис
+	Aggregations map[string]Aggregation `json:"aggregations"`
+	// Видалені (soft delete) товари повертаються лише на запит адміністратора
+	IncludeDeleted bool `json:"include_deleted"`
 
 	// Пагінація (Технічні поля)
 	From *int `json:"from"` // Скільки пропустити (Offset)
 	Size *int `json:"size"` // Скільки повернути (Limit)
+
+	// Курсорна пагінація: use_cursor відкриває курсор, cursor продовжує з next_cursor попередньої сторінки
+	UseCursor bool    `json:"use_cursor"`
+	Cursor    *string `json:"cursor"`
+}
+
+// Aggregation asks for bucket counts over one field of the matching items:
+// "terms" on status or seller, "range" or "histogram" on price or available_quantity.
+type Aggregation struct {
+	Type     string         `json:"type"`
+	Field    string         `json:"field"`
+	Size     *int           `json:"size"`     // terms
+	Interval *float64       `json:"interval"` // histogram
+	Ranges   []NumericRange `json:"ranges"`   // range
+}
+
+type NumericRange struct {
+	Key  *string  `json:"key"`
+	From *float64 `json:"from"`
+	To   *float64 `json:"to"`
 }
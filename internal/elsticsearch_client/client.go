@@ -3,11 +3,12 @@ package elsticsearch_client
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"net/http"
-	"strings"
-	"time"
+	"os"
 
+	"github.com/SerhiiKhyzhko/bookstore_items-api/config"
 	"github.com/SerhiiKhyzhko/bookstore_utils-go/logger"
 	"github.com/elastic/go-elasticsearch/v9"
 )
@@ -18,13 +19,28 @@ const (
 	itemMapping = `{
     	"settings": {
 	        "number_of_shards": 1,
-        	"number_of_replicas": 0
+        	"number_of_replicas": 0,
+        	"analysis": {
+    	        "analyzer": {
+	                "html_text": {
+                    	"type": "custom",
+                	    "char_filter": ["html_strip"],
+            	        "tokenizer": "standard",
+        	            "filter": ["lowercase"]
+    	            }
+	            }
+        	}
     	},
 	    "mappings": {
     	    "properties": {
 	            "title": {
                 	"type": "text",
-            	    "analyzer": "standard"
+            	    "analyzer": "standard",
+        	        "fields": {
+    	                "suggest": {
+	                        "type": "search_as_you_type"
+                    	}
+                	}
         	    },
     	        "description": {
 	                "properties": {
@@ -34,7 +50,7 @@ const (
     	                },
 	                    "html": {
                         	"type": "text",
-                    	    "analyzer": "standard" 
+                    	    "analyzer": "html_text"
                 	    }
             	    }
         	    },
@@ -58,6 +74,9 @@ const (
             	},
             	"sold_quantity": {
         	        "type": "integer"
+    	        },
+            	"reserved_quantity": {
+        	        "type": "integer"
     	        },
 	            "status": {
             	    "type": "keyword"
@@ -65,27 +84,55 @@ const (
     	        "date_created": {
 	                "type": "date",
                 	"format": "strict_date_optional_time"
+            	},
+    	        "date_updated": {
+	                "type": "date",
+                	"format": "strict_date_optional_time"
+            	},
+    	        "deleted_at": {
+	                "type": "date",
+                	"format": "strict_date_optional_time"
             	}
         	}
     	}
 	}`
 )
 
-func NewElasticClient(addreses string) (*elasticsearch.TypedClient, error) {
-	cfg := elasticsearch.Config{
-		Addresses: strings.Split(addreses, ";"),
-		Transport: &http.Transport{
-			TLSClientConfig:       &tls.Config{InsecureSkipVerify: true}, // Для локальної розробки
-			ResponseHeaderTimeout: 10 * time.Second,
-		},
-		// Якщо потрібно залогінитися під іншим користувачем
-		// Username: "elastic",
-		// Password: "changeme",
+// NewTransport builds the HTTP transport of the elasticsearch client, verifying the cluster
+// certificate against cfg.CACertPath when set and the system pool otherwise.
+// Callers keep it to close its idle connections on shutdown.
+func NewTransport(cfg config.ElasticsearchConfig) (*http.Transport, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CACertPath != "" {
+		caCert, err := os.ReadFile(cfg.CACertPath)
+		if err != nil {
+			return nil, fmt.Errorf("error when reading elasticsearch ca cert: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CACertPath)
+		}
+	}
+
+	return &http.Transport{
+		TLSClientConfig:       tlsConfig,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+	}, nil
+}
+
+func NewElasticClient(cfg config.ElasticsearchConfig, transport *http.Transport) (*elasticsearch.TypedClient, error) {
+	esConfig := elasticsearch.Config{
+		Addresses: cfg.Addresses,
+		Username:  cfg.Username,
+		Password:  cfg.Password,
+		Transport: transport,
+		// retries are done per call by the items client, which knows which calls are safe to repeat
+		DisableRetry: true,
 	}
 
 	var err error
 	var client *elasticsearch.TypedClient
-	client, err = elasticsearch.NewTypedClient(cfg)
+	client, err = elasticsearch.NewTypedClient(esConfig)
 	if err != nil {
 		logger.Error("Error creating elasticsearch typed client", err)
 		return nil, err
@@ -95,40 +142,29 @@ func NewElasticClient(addreses string) (*elasticsearch.TypedClient, error) {
 	if err != nil {
 		return nil, err
 	}
-	msg := fmt.Sprintf("Elasticsearch client connected. Claster: %s, Version %s", res.ClusterName, res.Version)
+	msg := fmt.Sprintf("Elasticsearch client connected. Claster: %s, Version %s", res.ClusterName, res.Version.Int)
 	logger.Info(msg)
 
 	return client, nil
 }
 
+// EnsureIndexCreated creates the first versioned index behind the items alias on an empty cluster.
+// An existing index is left alone, mapping changes are only applied by MigrateIndex.
 func EnsureIndexCreated(client *elasticsearch.TypedClient) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	exists, err := client.Indices.Exists(IndexItems).Do(ctx)
-
+	status, err := GetIndexStatus(client)
 	if err != nil {
 		logger.Error(fmt.Sprintf("error when check the index %s", IndexItems), err)
 		return err
 	}
 
-	if exists {
-		logger.Info(fmt.Sprintf("Indedx %s already exists", IndexItems))
-		return nil
-	}
-
-	resp, err := client.Indices.
-		Create(IndexItems).
-		Raw(strings.NewReader(itemMapping)).
-		Do(ctx)
-
-	if err != nil {
-		logger.Error(fmt.Sprintf("failed to create index %s", IndexItems), err)
-		return err
+	if status == nil {
+		return createFirstIndex(client)
 	}
 
-	if resp.Acknowledged {
-		logger.Info(fmt.Sprintf("index %s created successfully with settings and mappings", IndexItems))
+	if status.UpToDate {
+		logger.Info(fmt.Sprintf("Indedx %s already exists", status.Index))
+	} else {
+		logger.Info(fmt.Sprintf("index %s was created from an outdated mapping, run the index reindex command", status.Index))
 	}
 	return nil
 }
@@ -0,0 +1,414 @@
+package elsticsearch_client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SerhiiKhyzhko/bookstore_utils-go/logger"
+	"github.com/elastic/go-elasticsearch/v9"
+	"github.com/elastic/go-elasticsearch/v9/typedapi/core/reindex"
+	"github.com/elastic/go-elasticsearch/v9/typedapi/indices/updatealiases"
+	"github.com/elastic/go-elasticsearch/v9/typedapi/types"
+)
+
+const (
+	// versioned indices are named items_v1, items_v2... and served through the IndexItems alias
+	versionedIndexPrefix = IndexItems + "_v"
+	mappingHashKey       = "mapping_hash"
+
+	reindexPollInterval = 2 * time.Second
+	migrationTimeout    = time.Hour
+	// the catch up pass also copies documents updated a bit before the full copy started,
+	// date_updated is set by the clocks of the API servers, not of the machine running the migration
+	catchUpMargin = 5 * time.Minute
+)
+
+// IndexStatus describes the index currently served under the items name.
+type IndexStatus struct {
+	// Index is the concrete index, equal to IndexItems for the legacy index created before versioning
+	Index       string
+	Version     int
+	MappingHash string
+	// UpToDate is false when the index was created from a different itemMapping
+	UpToDate bool
+}
+
+// GetIndexStatus resolves the items alias, nil is returned when neither the alias nor an index exist.
+func GetIndexStatus(client *elasticsearch.TypedClient) (*IndexStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := client.Indices.Get(IndexItems).Do(ctx)
+	if err != nil {
+		var e *types.ElasticsearchError
+		if errors.As(err, &e) && e.Status == 404 {
+			return nil, nil
+		}
+		logger.Error(fmt.Sprintf("error when getting the index %s", IndexItems), err)
+		return nil, err
+	}
+
+	for index, state := range res {
+		status := &IndexStatus{Index: index}
+		if version, found := strings.CutPrefix(index, versionedIndexPrefix); found {
+			status.Version, _ = strconv.Atoi(version)
+		}
+		if state.Mappings != nil {
+			if rawHash, found := state.Mappings.Meta_[mappingHashKey]; found {
+				_ = json.Unmarshal(rawHash, &status.MappingHash)
+			}
+		}
+
+		currentHash, err := mappingHash()
+		if err != nil {
+			return nil, err
+		}
+		status.UpToDate = status.MappingHash == currentHash
+		return status, nil
+	}
+	return nil, nil
+}
+
+// MigrateIndex moves the items alias onto a new index built from the current itemMapping when the
+// served index was created from another mapping. Documents are copied with the reindex API and the
+// alias is swapped atomically, so readers never see a missing or half filled index. The full copy runs
+// while the served index keeps taking writes, then the index is write blocked and a catch up pass copies
+// the documents updated since the full copy started. Writes are only rejected as unavailable during that
+// short pass and the swap instead of being lost. Items purged while the full copy runs stay soft deleted
+// in the new index until the next purge. The previous versioned index is kept for rollback, the legacy
+// unversioned index is removed in the same atomic step since the alias takes over its name.
+// A failed migration deletes the half built index and lifts the write block.
+// force reindexes into a new version even when the mapping did not change.
+func MigrateIndex(client *elasticsearch.TypedClient, force bool) (*IndexStatus, error) {
+	status, err := GetIndexStatus(client)
+	if err != nil {
+		return nil, err
+	}
+
+	if status == nil {
+		if err := createFirstIndex(client); err != nil {
+			return nil, err
+		}
+		return GetIndexStatus(client)
+	}
+
+	if status.UpToDate && !force {
+		logger.Info(fmt.Sprintf("index %s is up to date, nothing to migrate", status.Index))
+		return status, nil
+	}
+
+	version, err := nextVersion(client)
+	if err != nil {
+		return nil, err
+	}
+	newIndex := versionedIndex(version)
+	logger.Info(fmt.Sprintf("migrating index %s to %s", status.Index, newIndex))
+
+	if err := createVersionedIndex(client, version, false); err != nil {
+		return nil, err
+	}
+	copyStarted := time.Now()
+	if err := reindexDocuments(client, status.Index, newIndex, nil); err != nil {
+		abortMigration(client, "", newIndex)
+		return nil, err
+	}
+	if err := setWriteBlock(client, status.Index, true); err != nil {
+		abortMigration(client, "", newIndex)
+		return nil, err
+	}
+	if err := catchUp(client, status.Index, newIndex, copyStarted.Add(-catchUpMargin)); err != nil {
+		abortMigration(client, status.Index, newIndex)
+		return nil, err
+	}
+	if err := swapAlias(client, status.Index, newIndex); err != nil {
+		abortMigration(client, status.Index, newIndex)
+		return nil, err
+	}
+	// the legacy index is gone with the swap, a kept version has to be writable again for a rollback
+	if status.Index != IndexItems {
+		if err := setWriteBlock(client, status.Index, false); err != nil {
+			logger.Error(fmt.Sprintf("failed to lift the write block of the previous index %s", status.Index), err)
+		}
+	}
+
+	logger.Info(fmt.Sprintf("alias %s now points to %s", IndexItems, newIndex))
+	return GetIndexStatus(client)
+}
+
+// catchUp copies again the documents of the write blocked source updated since the given time,
+// overwriting the copies the full pass made of them.
+func catchUp(client *elasticsearch.TypedClient, source string, dest string, since time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// writes acknowledged before the block may not be searchable yet
+	if _, err := client.Indices.Refresh().Index(source).Do(ctx); err != nil {
+		logger.Error(fmt.Sprintf("failed to refresh index %s", source), err)
+		return err
+	}
+
+	updatedSince := since.UTC().Format(time.RFC3339Nano)
+	return reindexDocuments(client, source, dest, &types.Query{
+		Range: map[string]types.RangeQuery{
+			"date_updated": types.DateRangeQuery{Gte: &updatedSince},
+		},
+	})
+}
+
+// abortMigration undoes a migration that failed before the alias moved, blockedIndex is empty
+// when the served index was not blocked yet.
+func abortMigration(client *elasticsearch.TypedClient, blockedIndex string, newIndex string) {
+	if blockedIndex != "" {
+		if err := setWriteBlock(client, blockedIndex, false); err != nil {
+			logger.Error(fmt.Sprintf("failed to lift the write block of %s, lift it by hand", blockedIndex), err)
+		}
+	}
+	if err := deleteIndices(client, []string{newIndex}); err != nil {
+		logger.Error(fmt.Sprintf("failed to delete the half built index %s, delete it by hand", newIndex), err)
+	}
+}
+
+// setWriteBlock rejects or accepts again every write to index, reads keep working.
+func setWriteBlock(client *elasticsearch.TypedClient, index string, blocked bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	body := fmt.Sprintf(`{"index.blocks.write": %t}`, blocked)
+	if _, err := client.Indices.PutSettings().Indices(index).Raw(strings.NewReader(body)).Do(ctx); err != nil {
+		logger.Error(fmt.Sprintf("failed to set the write block of index %s to %t", index, blocked), err)
+		return err
+	}
+	return nil
+}
+
+// versionedIndices lists every items_vN index, including old versions no longer behind the alias.
+func versionedIndices(client *elasticsearch.TypedClient) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := client.Indices.Get(versionedIndexPrefix + "*").Do(ctx)
+	if err != nil {
+		logger.Error(fmt.Sprintf("error when listing the %s* indices", versionedIndexPrefix), err)
+		return nil, err
+	}
+	indices := make([]string, 0, len(res))
+	for index := range res {
+		indices = append(indices, index)
+	}
+	return indices, nil
+}
+
+// nextVersion is one above the highest existing version, so indices kept for rollback
+// or left over by an interrupted run never collide with a new one.
+func nextVersion(client *elasticsearch.TypedClient) (int, error) {
+	indices, err := versionedIndices(client)
+	if err != nil {
+		return 0, err
+	}
+	highest := 0
+	for _, index := range indices {
+		if version, err := strconv.Atoi(strings.TrimPrefix(index, versionedIndexPrefix)); err == nil {
+			highest = max(highest, version)
+		}
+	}
+	return highest + 1, nil
+}
+
+func deleteIndices(client *elasticsearch.TypedClient, indices []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// indices are named one by one, wildcard deletes are refused by default
+	_, err := client.Indices.Delete(strings.Join(indices, ",")).Do(ctx)
+	return err
+}
+
+func versionedIndex(version int) string {
+	return versionedIndexPrefix + strconv.Itoa(version)
+}
+
+// mappingHash fingerprints itemMapping, it is stored in the index _meta to detect mapping drift.
+func mappingHash() (string, error) {
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, []byte(itemMapping)); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(compacted.Bytes())
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// indexBody is itemMapping with its hash in the mapping _meta, optionally registering the items alias.
+func indexBody(withAlias bool) (string, error) {
+	var body map[string]any
+	if err := json.Unmarshal([]byte(itemMapping), &body); err != nil {
+		return "", err
+	}
+
+	hash, err := mappingHash()
+	if err != nil {
+		return "", err
+	}
+	mappings, _ := body["mappings"].(map[string]any)
+	mappings["_meta"] = map[string]string{mappingHashKey: hash}
+	if withAlias {
+		body["aliases"] = map[string]any{IndexItems: map[string]any{}}
+	}
+
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
+
+func createVersionedIndex(client *elasticsearch.TypedClient, version int, withAlias bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	index := versionedIndex(version)
+	body, err := indexBody(withAlias)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Indices.Create(index).Raw(strings.NewReader(body)).Do(ctx)
+	if err != nil {
+		logger.Error(fmt.Sprintf("failed to create index %s", index), err)
+		return err
+	}
+
+	if resp.Acknowledged {
+		logger.Info(fmt.Sprintf("index %s created successfully with settings and mappings", index))
+	}
+	return nil
+}
+
+// reindexDocuments copies the documents of source matching query, or all of them when it is nil, into dest
+// as a background task and waits for it, a single request would hit the transport response timeout on big indices.
+func reindexDocuments(client *elasticsearch.TypedClient, source string, dest string, query *types.Query) error {
+	ctx, cancel := context.WithTimeout(context.Background(), migrationTimeout)
+	defer cancel()
+
+	res, err := client.Reindex().Request(&reindex.Request{
+		Source: types.ReindexSource{Index: []string{source}, Query: query},
+		Dest:   types.ReindexDestination{Index: dest},
+	}).WaitForCompletion(false).Do(ctx)
+	if err != nil {
+		logger.Error(fmt.Sprintf("failed to start reindex from %s to %s", source, dest), err)
+		return err
+	}
+	if res.Task == nil {
+		return fmt.Errorf("reindex from %s to %s returned no task", source, dest)
+	}
+
+	for {
+		task, err := client.Tasks.Get(*res.Task).Do(ctx)
+		if err != nil {
+			logger.Error(fmt.Sprintf("failed to check reindex task %s", *res.Task), err)
+			return err
+		}
+
+		if task.Completed {
+			if task.Error != nil {
+				return fmt.Errorf("reindex from %s to %s failed: %s", source, dest, task.Error.Type)
+			}
+
+			var result reindex.Response
+			if err := json.Unmarshal(task.Response, &result); err != nil {
+				return err
+			}
+			if len(result.Failures) > 0 {
+				return fmt.Errorf("reindex from %s to %s failed for %d documents", source, dest, len(result.Failures))
+			}
+			logger.Info(fmt.Sprintf("reindexed documents from %s to %s", source, dest))
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(reindexPollInterval):
+		}
+	}
+}
+
+// swapAlias points the items alias at newIndex and detaches oldIndex in a single atomic request.
+func swapAlias(client *elasticsearch.TypedClient, oldIndex string, newIndex string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	alias := IndexItems
+	actions := []types.IndicesAction{
+		{Add: &types.AddAction{Index: &newIndex, Alias: &alias}},
+	}
+	if oldIndex == IndexItems {
+		// the legacy index holds the name the alias needs
+		actions = append(actions, types.IndicesAction{RemoveIndex: &types.RemoveIndexAction{Index: &oldIndex}})
+	} else {
+		actions = append(actions, types.IndicesAction{Remove: &types.RemoveAction{Index: &oldIndex, Alias: &alias}})
+	}
+
+	if _, err := client.Indices.UpdateAliases().Request(&updatealiases.Request{Actions: actions}).Do(ctx); err != nil {
+		logger.Error(fmt.Sprintf("failed to move alias %s from %s to %s", IndexItems, oldIndex, newIndex), err)
+		return err
+	}
+	return nil
+}
+
+// DeleteIndex drops the index served under the items alias together with every previous version
+// kept by MigrateIndex, the alias goes away with them.
+func DeleteIndex(client *elasticsearch.TypedClient) error {
+	status, err := GetIndexStatus(client)
+	if err != nil {
+		return err
+	}
+	indices, err := versionedIndices(client)
+	if err != nil {
+		return err
+	}
+	if status != nil && !slices.Contains(indices, status.Index) {
+		indices = append(indices, status.Index)
+	}
+	if len(indices) == 0 {
+		logger.Info(fmt.Sprintf("index %s does not exist, nothing to delete", IndexItems))
+		return nil
+	}
+
+	if err := deleteIndices(client, indices); err != nil {
+		logger.Error(fmt.Sprintf("failed to delete indices %s", strings.Join(indices, ", ")), err)
+		return err
+	}
+	logger.Info(fmt.Sprintf("indices %s deleted", strings.Join(indices, ", ")))
+	return nil
+}
+
+func CountDocuments(client *elasticsearch.TypedClient) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := client.Count().Index(IndexItems).Do(ctx)
+	if err != nil {
+		logger.Error(fmt.Sprintf("failed to count documents of index %s", IndexItems), err)
+		return 0, err
+	}
+	return res.Count, nil
+}
+
+// createFirstIndex creates the index behind the items alias on a cluster that serves none,
+// skipping versions of indices that outlived the alias.
+func createFirstIndex(client *elasticsearch.TypedClient) error {
+	version, err := nextVersion(client)
+	if err != nil {
+		return err
+	}
+	return createVersionedIndex(client, version, true)
+}
@@ -1,9 +1,50 @@
 package item_errors
 
-import "errors"
+import (
+	"errors"
+	"strings"
+)
 
 var (
 	RequestTimeoutErr = errors.New("request timeout")
 	NotFoundErr = errors.New("item not found")
 	ParseErr = errors.New("error when trying to parse response")
+	ConflictErr = errors.New("item was modified concurrently")
+	ConcurrentUpdateErr = errors.New("item is being modified by another request")
+	OutOfStockErr = errors.New("not enough items in stock")
+	ForbiddenErr = errors.New("item belongs to another seller")
+	BadQueryErr = errors.New("invalid search query")
+	CursorExpiredErr = errors.New("search cursor expired, start the search again")
+	ValidationErr = errors.New("invalid item")
+	IllegalTransitionErr = errors.New("illegal status transition")
+	NotAvailableErr = errors.New("item is not available for sale")
+	UnavailableErr = errors.New("storage is temporarily unavailable")
+	TooManyRequestsErr = errors.New("storage is overloaded")
+	IndexMissingErr = errors.New("items index does not exist")
+	AlreadyExistsErr = errors.New("item already exists")
+	InvalidDocumentErr = errors.New("item was rejected by the index mapping")
 )
+
+// FieldError describes why a single field of an item was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid field of an item, errors.Is matches it with ValidationErr.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		messages = append(messages, field.Field+": "+field.Message)
+	}
+	return ValidationErr.Error() + ": " + strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ValidationErr
+}
@@ -1,14 +1,10 @@
 package main
 
 import (
-	"github.com/SerhiiKhyzhko/bookstore-oauth-go/oauth"
-	"github.com/SerhiiKhyzhko/bookstore_items-api/app"
-	"github.com/SerhiiKhyzhko/bookstore_items-api/clients/elasticsearch"
+	"os"
+
+	"github.com/SerhiiKhyzhko/bookstore_items-api/cli"
 	"github.com/SerhiiKhyzhko/bookstore_items-api/config"
-	"github.com/SerhiiKhyzhko/bookstore_items-api/controllers"
-	"github.com/SerhiiKhyzhko/bookstore_items-api/domain/items"
-	"github.com/SerhiiKhyzhko/bookstore_items-api/internal/elsticsearch_client"
-	"github.com/SerhiiKhyzhko/bookstore_items-api/services"
 	"github.com/SerhiiKhyzhko/bookstore_utils-go/logger"
 	"github.com/joho/godotenv"
 )
@@ -18,18 +14,11 @@ func main() {
 		logger.Info("Error loading .env file")
 	}
 
-	config.Init()
-	oauth.Init(config.RestyBaseUrl)
-	esClient, err := elsticsearch_client.NewElasticClient(config.EsHosts)
-	if err != nil{
-		logger.Fatal("CRITICAL: Failed to connect to Elasticsearch: ", err)
+	cfg, err := config.Load(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		logger.Fatal("CRITICAL: invalid configuration: ", err)
 	}
-	if err = elsticsearch_client.EnsureIndexCreated(esClient); err != nil {
-		logger.Fatal("CRITICAL: Failed to check/create index: ", err)
+	if err := cli.Run(cfg, os.Args[1:]); err != nil {
+		logger.Fatal("CRITICAL: command failed: ", err)
 	}
-	elasticsearch := elasticsearch.NewEsClient(esClient)
-	dao := items.NewItemDao(elasticsearch)
-	service := services.NewItemsService(dao)
-	controller := controllers.NewItemsController(service)
-	app.StartApp(controller)
 }
@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "items_api"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests by route and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	esRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "elasticsearch_requests_total",
+		Help:      "Number of elasticsearch calls by operation.",
+	}, []string{"operation"})
+
+	esRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "elasticsearch_request_duration_seconds",
+		Help:      "Elasticsearch call latency by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	esErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "elasticsearch_errors_total",
+		Help:      "Number of failed elasticsearch calls by operation and error type.",
+	}, []string{"operation", "type"})
+
+	esRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "elasticsearch_retries_total",
+		Help:      "Number of retried elasticsearch calls by operation.",
+	}, []string{"operation"})
+
+	esBreakerOpen = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "elasticsearch_circuit_breaker_open",
+		Help:      "1 while the elasticsearch circuit breaker rejects calls, 0 otherwise.",
+	})
+)
+
+// Handler exposes every registered collector in the prometheus text format.
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
+
+// Middleware records the count and latency of every request, labelled by the route pattern
+// rather than the raw path so item ids don't blow up the label cardinality.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+
+		httpRequests.WithLabelValues(c.Request.Method, route, status).Inc()
+		httpRequestDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// ObserveEsRequest records a single elasticsearch call, errorType is empty for successful calls.
+func ObserveEsRequest(operation string, duration time.Duration, errorType string) {
+	esRequests.WithLabelValues(operation).Inc()
+	esRequestDuration.WithLabelValues(operation).Observe(duration.Seconds())
+	if errorType != "" {
+		esErrors.WithLabelValues(operation, errorType).Inc()
+	}
+}
+
+// ObserveEsRetry counts an elasticsearch call that is attempted again after a transient failure.
+func ObserveEsRetry(operation string) {
+	esRetries.WithLabelValues(operation).Inc()
+}
+
+// SetEsBreakerOpen reports the state of the elasticsearch circuit breaker.
+func SetEsBreakerOpen(open bool) {
+	if open {
+		esBreakerOpen.Set(1)
+		return
+	}
+	esBreakerOpen.Set(0)
+}
@@ -0,0 +1,62 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	Header = "X-Request-ID"
+
+	maxLength = 128
+)
+
+type contextKey struct{}
+
+// Middleware tags every request with an id, reusing the one sent by the caller or a proxy
+// when it looks sane. The id is echoed in the X-Request-ID response header, put on the
+// request span and returned in error bodies so a failed call can be found in the logs.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(Header)
+		if !valid(id) {
+			id = generate()
+		}
+
+		ctx := context.WithValue(c.Request.Context(), contextKey{}, id)
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("request.id", id))
+		c.Request = c.Request.WithContext(ctx)
+		c.Header(Header, id)
+		c.Next()
+	}
+}
+
+// FromContext returns the id of the request ctx belongs to, empty outside of a request.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// valid only accepts printable ascii so a client can't inject anything into headers or logs.
+func valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func generate() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
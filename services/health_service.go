@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/SerhiiKhyzhko/bookstore_items-api/clients/elasticsearch"
+	"github.com/SerhiiKhyzhko/bookstore_items-api/domain/health"
+	"github.com/SerhiiKhyzhko/bookstore_items-api/internal/elsticsearch_client"
+	"github.com/elastic/go-elasticsearch/v9/typedapi/types/enums/healthstatus"
+)
+
+const healthCheckTimeout = 2 * time.Second
+
+type HealthServiceInterface interface {
+	Ready(context.Context) health.Report
+}
+
+type healthService struct {
+	esClient   elasticsearch.EsClientInterface
+	oauthUrl   string
+	httpClient *http.Client
+}
+
+func NewHealthService(esClient elasticsearch.EsClientInterface, oauthUrl string) *healthService {
+	return &healthService{
+		esClient:   esClient,
+		oauthUrl:   oauthUrl,
+		httpClient: &http.Client{Timeout: healthCheckTimeout},
+	}
+}
+
+// Ready runs every dependency check concurrently, a slow dependency costs at most healthCheckTimeout.
+func (s *healthService) Ready(ctx context.Context) health.Report {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	checks := map[string]func(context.Context) health.Check{
+		"elasticsearch": s.checkCluster,
+		"items_index":   s.checkIndex,
+		"oauth_api":     s.checkOauth,
+	}
+
+	report := health.Report{Status: health.StatusUp, Checks: make(map[string]health.Check, len(checks))}
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := check(ctx)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			report.Checks[name] = result
+			if result.Status != health.StatusUp {
+				report.Status = health.StatusDown
+			}
+		}()
+	}
+	wg.Wait()
+
+	return report
+}
+
+func (s *healthService) checkCluster(ctx context.Context) health.Check {
+	status, err := s.esClient.ClusterHealth(ctx)
+	if err != nil {
+		return health.Check{Status: health.StatusDown, Details: err.Error()}
+	}
+	// yellow clusters still serve every request, only red ones have missing primary shards
+	if status == healthstatus.Red {
+		return health.Check{Status: health.StatusDown, Details: "cluster status red"}
+	}
+	return health.Check{Status: health.StatusUp, Details: "cluster status " + status.String()}
+}
+
+func (s *healthService) checkIndex(ctx context.Context) health.Check {
+	exists, err := s.esClient.IndexExists(ctx, elsticsearch_client.IndexItems)
+	if err != nil {
+		return health.Check{Status: health.StatusDown, Details: err.Error()}
+	}
+	if !exists {
+		return health.Check{Status: health.StatusDown, Details: fmt.Sprintf("index %s does not exist", elsticsearch_client.IndexItems)}
+	}
+	return health.Check{Status: health.StatusUp}
+}
+
+// checkOauth only checks the OAuth API answers, any response below 500 counts as reachable.
+func (s *healthService) checkOauth(ctx context.Context) health.Check {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.oauthUrl, nil)
+	if err != nil {
+		return health.Check{Status: health.StatusDown, Details: err.Error()}
+	}
+
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return health.Check{Status: health.StatusDown, Details: err.Error()}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusInternalServerError {
+		return health.Check{Status: health.StatusDown, Details: fmt.Sprintf("responded with status %d", res.StatusCode)}
+	}
+	return health.Check{Status: health.StatusUp}
+}
@@ -2,18 +2,38 @@ package services
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
 
+	"github.com/SerhiiKhyzhko/bookstore_items-api/clients/elasticsearch"
 	"github.com/SerhiiKhyzhko/bookstore_items-api/domain/items"
 	"github.com/SerhiiKhyzhko/bookstore_items-api/domain/queries"
+	"github.com/SerhiiKhyzhko/bookstore_items-api/item_errors"
+	"github.com/SerhiiKhyzhko/bookstore_items-api/tracing"
+	"github.com/oklog/ulid/v2"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type ItemsServiceInterface interface {
 	Create(context.Context, items.Item) (*items.Item, error)
 	Get(context.Context, string) (*items.Item, error)
-	Search(context.Context, queries.EsQuery) ([]items.Item, error)
-	Delete(context.Context, string) error
+	Search(context.Context, queries.EsQuery) (*items.SearchResult, error)
+	Delete(context.Context, string, *elasticsearch.DocVersion) error
+	Restore(context.Context, string, *elasticsearch.DocVersion, int64, bool) (*items.Item, error)
+	Purge(context.Context, time.Duration) (int64, error)
 	Put(context.Context, items.Item)(*items.Item, error)
 	Patch(context.Context, items.PartialUpdateItem, string)(*items.Item, error)
+	Purchase(context.Context, string, items.StockRequest) (*items.Item, error)
+	Reserve(context.Context, string, items.StockRequest) (*items.Item, error)
+	Release(context.Context, string, items.StockRequest) (*items.Item, error)
+	ChangeStatus(context.Context, string, string, *elasticsearch.DocVersion) (*items.Item, error)
+	Bulk(context.Context, []items.BulkAction, int64, bool) (*items.BulkResponse, error)
+	Export(context.Context, int64, func([]items.Item) error) error
+	Suggest(context.Context, string, int) ([]string, error)
 }
 
 type itemsService struct{
@@ -24,14 +44,55 @@ func NewItemsService(itemDao items.ItemDaoInterface) *itemsService {
 	return &itemsService{itemDao: itemDao}
 }
 
-func (s *itemsService) Create(ctx context.Context, item items.Item) (*items.Item, error) {
+// how often a read-check-write without If-Match is redone after losing a race
+const maxUnguardedAttempts = 3
+
+// readCheckWrite runs a read-check-write whose write is guarded by a revision. With the client's
+// If-Match the write is made against that revision and a conflict means the client's precondition
+// failed. Without it attempt guards the write with the revision it just read, so a conflict only
+// means a concurrent write won the race, the whole attempt is redone with fresh data then.
+func readCheckWrite(clientVersion *elasticsearch.DocVersion, attempt func() error) error {
+	for i := 1; ; i++ {
+		err := attempt()
+		if clientVersion != nil || !errors.Is(err, item_errors.ConflictErr) {
+			return err
+		}
+		if i == maxUnguardedAttempts {
+			return item_errors.ConcurrentUpdateErr
+		}
+	}
+}
+
+func (s *itemsService) Create(ctx context.Context, item items.Item) (_ *items.Item, err error) {
+	ctx, span := tracing.Start(ctx, "itemsService.Create", attribute.Int64("item.seller", item.Seller))
+	defer tracing.End(span, &err)
+
+	if item.Status == "" {
+		item.Status = items.StatusActive
+	}
+	if err := item.ValidateNew(); err != nil {
+		return nil, err
+	}
+
+	// reservations only go through Reserve and Release, deletion through Delete and Restore
+	item.ReservedQuantity = 0
+	item.DeletedAt = nil
+	// ids are minted here, ULIDs keep them unique and sortable by creation time
+	item.Id = ulid.Make().String()
+	span.SetAttributes(attribute.String("item.id", item.Id))
+	item.DateCreated = time.Now().UTC()
+	item.DateUpdated = item.DateCreated
+
 	if err := s.itemDao.Save(ctx, item); err != nil{
 		return nil, err
 	}
 	return &item, nil
 }
 
-func (s *itemsService) Get(ctx context.Context, id string) (*items.Item, error) {
+func (s *itemsService) Get(ctx context.Context, id string) (_ *items.Item, err error) {
+	ctx, span := tracing.Start(ctx, "itemsService.Get", attribute.String("item.id", id))
+	defer tracing.End(span, &err)
+
 	result, err := s.itemDao.Get(ctx, id)
 	if err != nil {
 		return nil, err
@@ -42,25 +103,374 @@ func (s *itemsService) Get(ctx context.Context, id string) (*items.Item, error)
 	return result, nil
 }
 
-func (s *itemsService) Search(ctx context.Context, query queries.EsQuery) ([]items.Item, error) {
+func (s *itemsService) Search(ctx context.Context, query queries.EsQuery) (_ *items.SearchResult, err error) {
+	ctx, span := tracing.Start(ctx, "itemsService.Search")
+	defer tracing.End(span, &err)
+
+	if query.Status != nil && *query.Status != "" && !items.IsValidStatus(*query.Status) {
+		return nil, fmt.Errorf("%w: status must be one of %s", item_errors.BadQueryErr, strings.Join(items.Statuses, ", "))
+	}
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
 	return s.itemDao.Search(ctx, query)
 }
 
-func (s *itemsService) Delete(ctx context.Context, id string) error {
-	return s.itemDao.Delete(ctx, id)
+func (s *itemsService) Delete(ctx context.Context, id string, version *elasticsearch.DocVersion) (err error) {
+	ctx, span := tracing.Start(ctx, "itemsService.Delete", attribute.String("item.id", id))
+	defer tracing.End(span, &err)
+
+	// guarding the soft delete with a revision makes it safe to retry
+	return readCheckWrite(version, func() error {
+		guard := version
+		if guard == nil {
+			current, err := s.itemDao.Get(ctx, id)
+			if err != nil {
+				return err
+			}
+			guard = current.Version
+		}
+		return s.itemDao.Delete(ctx, id, guard)
+	})
 }
 
-func (s *itemsService) Put(ctx context.Context, item items.Item) (*items.Item, error) {
-	if err := s.itemDao.Put(ctx, item); err != nil{
+// Restore brings back a soft deleted item on behalf of seller, admins may restore items of any seller.
+func (s *itemsService) Restore(ctx context.Context, id string, version *elasticsearch.DocVersion, seller int64, admin bool) (_ *items.Item, err error) {
+	ctx, span := tracing.Start(ctx, "itemsService.Restore", attribute.String("item.id", id))
+	defer tracing.End(span, &err)
+
+	err = readCheckWrite(version, func() error {
+		current, err := s.itemDao.GetWithDeleted(ctx, id)
+		if err != nil {
+			return err
+		}
+		if current.Seller != seller && !admin {
+			return item_errors.ForbiddenErr
+		}
+		if current.DeletedAt == nil {
+			return fmt.Errorf("%w: item is not deleted", item_errors.IllegalTransitionErr)
+		}
+		guard := version
+		if guard == nil {
+			guard = current.Version
+		}
+		return s.itemDao.Restore(ctx, id, guard)
+	})
+	if err != nil {
 		return nil, err
 	}
-	return &item, nil
+	return s.itemDao.Get(ctx, id)
 }
 
-func (s *itemsService) Patch(ctx context.Context, item items.PartialUpdateItem, id string) (*items.Item, error) {
-	if err := s.itemDao.Patch(ctx, item, id); err != nil{
+// Purge permanently removes the items deleted longer than retention ago.
+func (s *itemsService) Purge(ctx context.Context, retention time.Duration) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "itemsService.Purge", attribute.String("retention", retention.String()))
+	defer tracing.End(span, &err)
+
+	purged, err := s.itemDao.Purge(ctx, time.Now().Add(-retention))
+	span.SetAttributes(attribute.Int64("items.purged", purged))
+	return purged, err
+}
+
+func (s *itemsService) Put(ctx context.Context, item items.Item) (_ *items.Item, err error) {
+	ctx, span := tracing.Start(ctx, "itemsService.Put", attribute.String("item.id", item.Id))
+	defer tracing.End(span, &err)
+
+	var result items.Item
+	err = readCheckWrite(item.Version, func() error {
+		update := item
+		update.DeletedAt = nil
+		current, err := s.itemDao.Get(ctx, update.Id)
+		if err != nil {
+			return err
+		}
+		if update.Status == "" {
+			update.Status = current.Status
+		}
+		// reservations only change through Reserve and Release, keep the ones of the guarded revision
+		update.ReservedQuantity = current.ReservedQuantity
+		if err := update.Validate(); err != nil {
+			return err
+		}
+		if err := items.CheckUpdate(current.Status, update.Status); err != nil {
+			return err
+		}
+		// the status check only holds for the revision it was made against
+		if update.Version == nil {
+			update.Version = current.Version
+		}
+
+		update.DateUpdated = time.Now().UTC()
+		if err := s.itemDao.Put(ctx, &update); err != nil {
+			return err
+		}
+		result = update
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (s *itemsService) Patch(ctx context.Context, item items.PartialUpdateItem, id string) (_ *items.Item, err error) {
+	ctx, span := tracing.Start(ctx, "itemsService.Patch", attribute.String("item.id", id))
+	defer tracing.End(span, &err)
+
+	if err := item.Validate(); err != nil {
+		return nil, err
+	}
+	err = readCheckWrite(item.Version, func() error {
+		update := item
+		current, err := s.itemDao.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		status := current.Status
+		if update.Status != nil {
+			status = *update.Status
+		}
+		if err := items.CheckUpdate(current.Status, status); err != nil {
+			return err
+		}
+		if update.Version == nil {
+			update.Version = current.Version
+		}
+
+		now := time.Now().UTC()
+		update.DateUpdated = &now
+		return s.itemDao.Patch(ctx, update, id)
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	return s.itemDao.Get(ctx, id)
+}
+
+func (s *itemsService) Purchase(ctx context.Context, id string, request items.StockRequest) (_ *items.Item, err error) {
+	ctx, span := tracing.Start(ctx, "itemsService.Purchase", attribute.String("item.id", id))
+	defer tracing.End(span, &err)
+
+	return s.updateStock(ctx, id, items.StockPurchase, request)
+}
+
+func (s *itemsService) Reserve(ctx context.Context, id string, request items.StockRequest) (_ *items.Item, err error) {
+	ctx, span := tracing.Start(ctx, "itemsService.Reserve", attribute.String("item.id", id))
+	defer tracing.End(span, &err)
+
+	return s.updateStock(ctx, id, items.StockReserve, request)
+}
+
+func (s *itemsService) Release(ctx context.Context, id string, request items.StockRequest) (_ *items.Item, err error) {
+	ctx, span := tracing.Start(ctx, "itemsService.Release", attribute.String("item.id", id))
+	defer tracing.End(span, &err)
+
+	return s.updateStock(ctx, id, items.StockRelease, request)
+}
+
+func (s *itemsService) updateStock(ctx context.Context, id string, operation items.StockOperation, request items.StockRequest) (*items.Item, error) {
+	if err := s.itemDao.UpdateStock(ctx, id, operation, request); err != nil {
+		// the stock script refuses both missing stock and items that don't take orders, tell them apart
+		if errors.Is(err, item_errors.OutOfStockErr) && operation != items.StockRelease {
+			current, getErr := s.itemDao.Get(ctx, id)
+			if errors.Is(getErr, item_errors.NotFoundErr) {
+				return nil, getErr
+			}
+			if getErr == nil && !items.AcceptsOrders(current.Status) {
+				return nil, item_errors.NotAvailableErr
+			}
+		}
+		return nil, err
+	}
+
+	return s.itemDao.Get(ctx, id)
+}
+
+// ChangeStatus moves the item along its lifecycle, publishing an item without stock marks it sold out.
+// The transition is checked against the requested status, sold out is only the stored outcome.
+func (s *itemsService) ChangeStatus(ctx context.Context, id string, status string, version *elasticsearch.DocVersion) (_ *items.Item, err error) {
+	ctx, span := tracing.Start(ctx, "itemsService.ChangeStatus", attribute.String("item.id", id), attribute.String("item.status", status))
+	defer tracing.End(span, &err)
+
+	err = readCheckWrite(version, func() error {
+		current, err := s.itemDao.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := items.CheckTransition(current.Status, status); err != nil {
+			return err
+		}
+		target := status
+		if target == items.StatusActive && current.AvailableQuantity == 0 {
+			target = items.StatusSoldOut
+		}
+		guard := version
+		if guard == nil {
+			guard = current.Version
+		}
+
+		now := time.Now().UTC()
+		return s.itemDao.Patch(ctx, items.PartialUpdateItem{Status: &target, DateUpdated: &now, Version: guard}, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.itemDao.Get(ctx, id)
+}
+
+// Bulk applies the actions on behalf of seller, admins may update and delete items of any seller.
+// Actions failing validation or ownership checks are reported per line and never sent to elasticsearch.
+func (s *itemsService) Bulk(ctx context.Context, actions []items.BulkAction, seller int64, admin bool) (_ *items.BulkResponse, err error) {
+	ctx, span := tracing.Start(ctx, "itemsService.Bulk", attribute.Int("bulk.actions", len(actions)))
+	defer tracing.End(span, &err)
+
+	var ids []string
+	for _, action := range actions {
+		if action.Action != items.BulkCreate && action.Id != "" {
+			ids = append(ids, action.Id)
+		}
+	}
+	existing, err := s.itemDao.GetMany(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &items.BulkResponse{Items: make([]items.BulkResult, len(actions))}
+	defer func() { span.SetAttributes(attribute.Bool("bulk.errors", response.Errors)) }()
+	var operations []elasticsearch.BulkOperation
+	var operationLines []int
+	now := time.Now().UTC()
+	for index, action := range actions {
+		response.Items[index] = items.BulkResult{Line: action.Line, Action: action.Action, Id: action.Id}
+		operation, err := bulkOperation(action, existing, seller, admin, now)
+		if err != nil {
+			response.Items[index].Status = bulkErrorStatus(err)
+			response.Items[index].Error = err.Error()
+			var validationErr *item_errors.ValidationError
+			if errors.As(err, &validationErr) {
+				response.Items[index].FieldErrors = validationErr.Fields
+			}
+			response.Errors = true
+			continue
+		}
+		response.Items[index].Id = operation.Id
+		operations = append(operations, *operation)
+		operationLines = append(operationLines, index)
+	}
+
+	if len(operations) == 0 {
+		return response, nil
+	}
+
+	results, err := s.itemDao.Bulk(ctx, operations)
+	if err != nil {
+		return nil, err
+	}
+	for index, result := range results {
+		itemResult := &response.Items[operationLines[index]]
+		itemResult.Status = result.Status
+		itemResult.Error = result.Error
+		// updates are guarded by the revision they were checked against, a conflict means it changed since
+		if result.Status == http.StatusConflict && operations[index].Version != nil {
+			itemResult.Error = item_errors.ConflictErr.Error()
+		}
+		if result.Error != "" {
+			response.Errors = true
+		}
+	}
+
+	return response, nil
+}
+
+func bulkOperation(action items.BulkAction, existing map[string]*items.Item, seller int64, admin bool, now time.Time) (*elasticsearch.BulkOperation, error) {
+	if action.Action == items.BulkCreate {
+		var item items.Item
+		if err := json.Unmarshal(action.Item, &item); err != nil {
+			return nil, errors.New("invalid item json")
+		}
+		if item.Status == "" {
+			item.Status = items.StatusActive
+		}
+		// admins import exported catalogs, whose items may be in any status and sold already
+		validate := item.ValidateNew
+		if admin {
+			validate = item.Validate
+		}
+		if err := validate(); err != nil {
+			return nil, err
+		}
+		item.Id = ulid.Make().String()
+		item.Seller = seller
+		item.ReservedQuantity = 0
+		item.DeletedAt = nil
+		item.DateCreated = now
+		item.DateUpdated = now
+		return &elasticsearch.BulkOperation{Action: elasticsearch.BulkCreate, Id: item.Id, Document: item}, nil
+	}
+
+	if action.Action != items.BulkUpdate && action.Action != items.BulkDelete {
+		return nil, errors.New("unknown action, expected create, update or delete")
+	}
+	if action.Id == "" {
+		return nil, errors.New("id is required")
+	}
+	current, found := existing[action.Id]
+	if !found {
+		return nil, item_errors.NotFoundErr
+	}
+	if current.Seller != seller && !admin {
+		return nil, item_errors.ForbiddenErr
+	}
+
+	if action.Action == items.BulkDelete {
+		// deletes are soft, like the ones of the API
+		deletion := items.DeletionUpdate{DeletedAt: &now, DateUpdated: now}
+		return &elasticsearch.BulkOperation{Action: elasticsearch.BulkUpdate, Id: action.Id, Document: deletion, Version: current.Version}, nil
+	}
+
+	var update items.PartialUpdateItem
+	if err := json.Unmarshal(action.Item, &update); err != nil {
+		return nil, errors.New("invalid update item json")
+	}
+	if err := update.Validate(); err != nil {
+		return nil, err
+	}
+	status := current.Status
+	if update.Status != nil {
+		status = *update.Status
+	}
+	if err := items.CheckUpdate(current.Status, status); err != nil {
+		return nil, err
+	}
+	update.DateUpdated = &now
+	// the status check only holds for the revision it was made against
+	return &elasticsearch.BulkOperation{Action: elasticsearch.BulkUpdate, Id: action.Id, Document: update, Version: current.Version}, nil
+}
+
+func bulkErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, item_errors.NotFoundErr):
+		return http.StatusNotFound
+	case errors.Is(err, item_errors.ForbiddenErr):
+		return http.StatusForbidden
+	case errors.Is(err, item_errors.IllegalTransitionErr):
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+func (s *itemsService) Export(ctx context.Context, seller int64, handle func([]items.Item) error) (err error) {
+	ctx, span := tracing.Start(ctx, "itemsService.Export", attribute.Int64("item.seller", seller))
+	defer tracing.End(span, &err)
+
+	return s.itemDao.Export(ctx, seller, handle)
+}
+
+func (s *itemsService) Suggest(ctx context.Context, text string, size int) (_ []string, err error) {
+	ctx, span := tracing.Start(ctx, "itemsService.Suggest")
+	defer tracing.End(span, &err)
+
+	return s.itemDao.Suggest(ctx, text, size)
 }
\ No newline at end of file